@@ -0,0 +1,108 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// errNotInjected is returned when heka refused the message
+var errNotInjected = errors.New("the message was not injected")
+
+// injection is a pack waiting for its injection result (in ack mode)
+type injection struct {
+	pack *pipeline.PipelinePack
+	res  chan bool
+}
+
+// ackResult is the response of a single message in ack mode
+type ackResult struct {
+	UUID string `json:"uuid"`
+}
+
+// inject hands the pack over to Run - in ack mode, waits for the result of
+// the injection, too. After Run returned, the pack is recycled.
+// The message is remembered as a recent message of the route.
+// Returns the uuid of the message: after the hand-over, the pack belongs to
+// the pipeline, and must not be read.
+func (hsi *HTTPSimpleInput) inject(pack *pipeline.PipelinePack, rt *route) (string, error) {
+	id := pack.Message.GetUuidString()
+	if hsi.recent == nil {
+		return id, hsi.handOver(pack)
+	}
+	msg := message.CopyMessage(pack.Message)
+	if err := hsi.handOver(pack); err != nil {
+		return "", err
+	}
+	hsi.recent.add(rt, msg)
+	return id, nil
+}
+
+// handOver hands the pack over to Run, see inject
+func (hsi *HTTPSimpleInput) handOver(pack *pipeline.PipelinePack) error {
+	if !hsi.ack {
+		select {
+		case hsi.input <- pack:
+			return nil
+		case <-hsi.done:
+			pack.Recycle()
+			return errStopping
+		}
+	}
+	in := injection{pack: pack, res: make(chan bool, 1)}
+	select {
+	case hsi.acks <- in:
+	case <-hsi.done:
+		pack.Recycle()
+		return errStopping
+	}
+	// Run always answers what it has received
+	if !<-in.res {
+		return errNotInjected
+	}
+	return nil
+}
+
+// decodePack decodes the Heka JSON or protobuf message in the pack's
+// MsgBytes, as the JSON and PROTOCOL_BUFFER decoders would do.
+// A missing uuid is left for the caller to set.
+func decodePack(pack *pipeline.PipelinePack, kind string) error {
+	var err error
+	if kind == "PROTOCOL_BUFFER" {
+		err = pack.Message.Unmarshal(pack.MsgBytes)
+	} else {
+		err = json.Unmarshal(pack.MsgBytes, pack.Message)
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding %s message: %s", kind, err)
+	}
+	pack.Decoded = true
+	return nil
+}
+
+// writeCreated answers 201 - in ack mode, with the uuid of the message
+func (hsi *HTTPSimpleInput) writeCreated(w http.ResponseWriter, id string) {
+	if !hsi.ack {
+		w.WriteHeader(201)
+		w.Write([]byte{})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(ackResult{UUID: id})
+}
@@ -0,0 +1,172 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAck(t *testing.T) {
+	hsi, _ := newTestInput(4)
+	hsi.ack = true
+	hsi.acks = make(chan injection)
+	hsi.done = make(chan struct{})
+	defer close(hsi.done)
+	// accepts every second injection, as Run with ir.Inject would
+	go func() {
+		var n int
+		for in := range hsi.acks {
+			in.res <- n%2 == 0
+			n++
+		}
+	}()
+	defer close(hsi.acks)
+
+	post := func(ct, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/?logger=acker", strings.NewReader(body))
+		r.Header.Set("Content-Type", ct)
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w
+	}
+
+	w := post("text/plain", "first")
+	if w.Code != 201 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	var res ackResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res.UUID) != 36 {
+		t.Errorf("bad response %q (%v)", w.Body, err)
+	}
+	if w = post("text/plain", "second"); w.Code != 503 {
+		t.Errorf("refused injection: got %d: %s", w.Code, w.Body)
+	}
+
+	// Heka JSON is decoded by the input in ack mode
+	if w = post("application/json", `{"type":`); w.Code != 400 {
+		t.Errorf("bad JSON: got %d: %s", w.Code, w.Body)
+	}
+	w = post("application/json", `{"type": "decoded", "payload": "third"}`)
+	if w.Code != 201 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAckAfterRecycle(t *testing.T) {
+	hsi, _ := newTestInput(4)
+	hsi.ack = true
+	hsi.batchDelim = []byte{'\n'}
+	hsi.acks = make(chan injection)
+	hsi.done = make(chan struct{})
+	defer close(hsi.done)
+	// the pipeline recycles the pack right after the injection
+	injected := make(chan string, 4)
+	go func() {
+		for in := range hsi.acks {
+			injected <- in.pack.Message.GetUuidString()
+			in.pack.Message.Uuid = nil
+			in.res <- true
+		}
+	}()
+	defer close(hsi.acks)
+
+	r, _ := http.NewRequest("POST", "/", strings.NewReader("one\ntwo\n"))
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	var res batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("cannot parse response %q: %s", w.Body, err)
+	}
+	if len(res.UUIDs) != 2 || res.UUIDs[0] != <-injected || res.UUIDs[1] != <-injected {
+		t.Errorf("got %+v", res)
+	}
+
+	hsi.batchDelim = nil
+	r, _ = http.NewRequest("POST", "/", strings.NewReader("three"))
+	w = httptest.NewRecorder()
+	hsi.handler(w, r)
+	var ar ackResult
+	if err := json.Unmarshal(w.Body.Bytes(), &ar); err != nil {
+		t.Fatalf("cannot parse response %q: %s", w.Body, err)
+	}
+	if id := <-injected; ar.UUID != id || id == "" {
+		t.Errorf("got %q, wanted %q", ar.UUID, id)
+	}
+}
+
+func TestAckDecodedStamps(t *testing.T) {
+	hsi, _ := newTestInput(4)
+	hsi.ack = true
+	hsi.acks = make(chan injection)
+	hsi.done = make(chan struct{})
+	defer close(hsi.done)
+	msgs := make(chan *message.Message, 4)
+	go func() {
+		for in := range hsi.acks {
+			msgs <- in.pack.Message
+			in.res <- true
+		}
+	}()
+	defer close(hsi.acks)
+	hsi.auths = []authenticator{tokenAuth{{Token: "t0k", Name: "ci", Logger: "ci-logger", Hostname: "ci-host"},
+		{Token: "t1k", Name: "plain"}}}
+	hsi.headers = []string{"X-Request-Id"}
+
+	// the token's overrides win over the decoded message's
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(
+		`{"type": "decoded", "payload": "x", "logger": "spoofed", "hostname": "spoofed"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer t0k")
+	r.Header.Set("User-Agent", "tester")
+	r.Header.Set("X-Request-Id", "r-1")
+	r.RemoteAddr = "192.0.2.1:4321"
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{
+		{{Subject: pkix.Name{CommonName: "client-1"}}}}}
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 201 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	m := <-msgs
+	if m.GetLogger() != "ci-logger" || m.GetHostname() != "ci-host" {
+		t.Errorf("got %s", m)
+	}
+	for k, v := range map[string]string{"ClientSubject": "CN=client-1", "AuthIdentity": "token:ci",
+		"RemoteAddr": "192.0.2.1", "UserAgent": "tester", "RequestPath": "/", "Header.X-Request-Id": "r-1"} {
+		if f := m.FindFirstField(k); f == nil || f.GetValue() != v {
+			t.Errorf("field %s is %v, wanted %q", k, f, v)
+		}
+	}
+
+	// without a hostname, it is the client's address
+	r, _ = http.NewRequest("POST", "/", strings.NewReader(`{"type": "decoded", "payload": "y"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer t1k")
+	r.RemoteAddr = "192.0.2.1:4321"
+	w = httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 201 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	if m = <-msgs; m.GetHostname() != "192.0.2.1" {
+		t.Errorf("got %s", m)
+	}
+}
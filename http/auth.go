@@ -0,0 +1,313 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"golang.org/x/crypto/bcrypt"
+
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header names used by the HMAC request signing.
+// The signature is the hex encoded HMAC-SHA256 of
+// timestamp + "\n" + method + "\n" + request URI + "\n" + body,
+// keyed with the secret named in the X-Heka-Key header.
+const (
+	HMACKeyHeader       = "X-Heka-Key"
+	HMACTimestampHeader = "X-Heka-Timestamp"
+	HMACSignatureHeader = "X-Heka-Signature"
+)
+
+// AuthConfig holds the authentication settings.
+// If none of them is given, every request is accepted.
+type AuthConfig struct {
+	// HtpasswdFile holds user:hash lines, with bcrypt or {SHA} hashes
+	HtpasswdFile string        `toml:"htpasswd_file"`
+	Tokens       []TokenConfig `toml:"tokens"`
+	// HMACKeys maps the key names to the secrets
+	HMACKeys map[string]string `toml:"hmac_keys"`
+	// MaxSkew is the maximal accepted difference between the signature's
+	// timestamp and our clock (defaults to 5m)
+	MaxSkew string `toml:"max_skew"`
+}
+
+// TokenConfig is one accepted bearer token, with the overrides
+// applied to the messages sent with it
+type TokenConfig struct {
+	Token    string `toml:"token"`
+	Name     string `toml:"name"`
+	Logger   string `toml:"logger"`
+	Hostname string `toml:"hostname"`
+}
+
+// identity is the authenticated sender of a request
+type identity struct {
+	Name     string
+	Logger   string
+	Hostname string
+}
+
+// apply sets the logger and hostname overrides of id, and records it
+// in the AuthIdentity field
+func (id *identity) apply(msg *message.Message) {
+	if id == nil {
+		return
+	}
+	if id.Logger != "" {
+		msg.SetLogger(id.Logger)
+	}
+	if id.Hostname != "" {
+		msg.SetHostname(id.Hostname)
+	}
+	if f, e := message.NewField("AuthIdentity", id.Name, ""); e == nil {
+		msg.AddField(f)
+	}
+}
+
+// authError is an authentication failure, with the HTTP status to return
+type authError struct {
+	Code int
+	Msg  string
+	// Challenges are the WWW-Authenticate values of a 401
+	Challenges []string
+}
+
+func (ae authError) Error() string {
+	return ae.Msg
+}
+
+func unauthorized(format string, args ...interface{}) error {
+	return authError{Code: http.StatusUnauthorized, Msg: fmt.Sprintf(format, args...)}
+}
+
+func forbidden(format string, args ...interface{}) error {
+	return authError{Code: http.StatusForbidden, Msg: fmt.Sprintf(format, args...)}
+}
+
+// withChallenge adds the challenge to err if it is a 401
+func withChallenge(err error, challenge string) error {
+	if ae, ok := err.(authError); ok && ae.Code == http.StatusUnauthorized {
+		ae.Challenges = append(ae.Challenges, challenge)
+		return ae
+	}
+	return err
+}
+
+// authenticator is one authentication method
+type authenticator interface {
+	// authenticate returns the identity of the sender, nil if the request
+	// has no credentials for this method, or an error if the credentials
+	// are invalid
+	authenticate(r *http.Request) (*identity, error)
+	// challenge is the WWW-Authenticate value of the method
+	challenge() string
+}
+
+// newAuthenticators returns the authenticators configured in ac
+func (ac AuthConfig) newAuthenticators() ([]authenticator, error) {
+	var auths []authenticator
+	if ac.HtpasswdFile != "" {
+		ba, err := readHtpasswd(ac.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, ba)
+	}
+	if len(ac.Tokens) > 0 {
+		ta := make(tokenAuth, 0, len(ac.Tokens))
+		for i, tc := range ac.Tokens {
+			if tc.Token == "" {
+				return nil, fmt.Errorf("empty token in tokens[%d]", i)
+			}
+			if tc.Name == "" {
+				tc.Name = strconv.Itoa(i + 1)
+			}
+			ta = append(ta, tc)
+		}
+		auths = append(auths, ta)
+	}
+	if len(ac.HMACKeys) > 0 {
+		ha := hmacAuth{keys: make(map[string][]byte, len(ac.HMACKeys)), maxSkew: 5 * time.Minute}
+		for k, v := range ac.HMACKeys {
+			if v == "" {
+				return nil, fmt.Errorf("empty secret for HMAC key %q", k)
+			}
+			ha.keys[k] = []byte(v)
+		}
+		if ac.MaxSkew != "" {
+			var err error
+			if ha.maxSkew, err = time.ParseDuration(ac.MaxSkew); err != nil {
+				return nil, fmt.Errorf("error parsing max_skew %q: %s", ac.MaxSkew, err)
+			}
+		}
+		auths = append(auths, ha)
+	}
+	return auths, nil
+}
+
+// authenticate checks the request with the configured authenticators.
+// If there is none, every request is accepted with a nil identity.
+func (hsi *HTTPSimpleInput) authenticate(r *http.Request) (*identity, error) {
+	if len(hsi.auths) == 0 {
+		return nil, nil
+	}
+	for _, a := range hsi.auths {
+		id, err := a.authenticate(r)
+		if err != nil || id != nil {
+			return id, withChallenge(err, a.challenge())
+		}
+	}
+	err := unauthorized("authentication needed")
+	for _, a := range hsi.auths {
+		err = withChallenge(err, a.challenge())
+	}
+	return nil, err
+}
+
+// basicAuth checks Basic authentication against htpasswd entries
+type basicAuth map[string]string
+
+func readHtpasswd(fn string) (basicAuth, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening htpasswd file %s: %s", fn, err)
+	}
+	defer fh.Close()
+	ba := make(basicAuth, 8)
+	scanner := bufio.NewScanner(fh)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: no user:hash found", fn, n)
+		}
+		user, hash := line[:i], line[i+1:]
+		if !strings.HasPrefix(hash, "{SHA}") && !strings.HasPrefix(hash, "$2") {
+			return nil, fmt.Errorf("%s:%d: only bcrypt and {SHA} hashes are supported", fn, n)
+		}
+		ba[user] = hash
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading htpasswd file %s: %s", fn, err)
+	}
+	return ba, nil
+}
+
+func (ba basicAuth) authenticate(r *http.Request) (*identity, error) {
+	user, passw, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	// an unknown user and a bad password are not told apart
+	hash, ok := ba[user]
+	if !ok {
+		return nil, unauthorized("bad user name or password")
+	}
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(passw))
+		ok = subtle.ConstantTimeCompare(
+			[]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(hash[5:])) == 1
+	} else {
+		ok = bcrypt.CompareHashAndPassword([]byte(hash), []byte(passw)) == nil
+	}
+	if !ok {
+		return nil, unauthorized("bad user name or password")
+	}
+	return &identity{Name: "basic:" + user}, nil
+}
+
+func (ba basicAuth) challenge() string { return `Basic realm="hekad"` }
+
+// tokenAuth checks the "Authorization: Bearer <token>" header
+type tokenAuth []TokenConfig
+
+func (ta tokenAuth) authenticate(r *http.Request) (*identity, error) {
+	token := r.Header.Get("Authorization")
+	if len(token) < 7 || !strings.EqualFold(token[:7], "Bearer ") {
+		return nil, nil
+	}
+	token = strings.TrimSpace(token[7:])
+	for _, tc := range ta {
+		if subtle.ConstantTimeCompare([]byte(tc.Token), []byte(token)) == 1 {
+			return &identity{Name: "token:" + tc.Name, Logger: tc.Logger, Hostname: tc.Hostname}, nil
+		}
+	}
+	return nil, unauthorized("unknown token")
+}
+
+func (ta tokenAuth) challenge() string { return `Bearer realm="hekad"` }
+
+// hmacAuth checks the HMAC-SHA256 signature of the request
+type hmacAuth struct {
+	keys    map[string][]byte
+	maxSkew time.Duration
+}
+
+func (ha hmacAuth) authenticate(r *http.Request) (*identity, error) {
+	keyName := r.Header.Get(HMACKeyHeader)
+	if keyName == "" {
+		return nil, nil
+	}
+	key, ok := ha.keys[keyName]
+	if !ok {
+		return nil, unauthorized("unknown HMAC key %q", keyName)
+	}
+	ts := r.Header.Get(HMACTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, forbidden("bad %s %q: %s", HMACTimestampHeader, ts, err)
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > ha.maxSkew || skew < -ha.maxSkew {
+		return nil, forbidden("timestamp %s is out of the accepted window", ts)
+	}
+	sig, err := hex.DecodeString(r.Header.Get(HMACSignatureHeader))
+	if err != nil || len(sig) == 0 {
+		return nil, forbidden("bad %s", HMACSignatureHeader)
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", ts, r.Method, r.URL.RequestURI())
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, forbidden("bad signature")
+	}
+	return &identity{Name: "hmac:" + keyName}, nil
+}
+
+func (ha hmacAuth) challenge() string { return `HMAC-SHA256 realm="hekad"` }
@@ -0,0 +1,185 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"golang.org/x/crypto/bcrypt"
+
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHtpasswd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hsi-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hash, err := bcrypt.GenerateFromPassword([]byte("bpass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, "htpasswd")
+	// {SHA} of "spass"
+	if err = ioutil.WriteFile(fn, []byte("# users\n\nbob:"+string(hash)+
+		"\nsam:{SHA}Qxr7TE8aYM8LctBqgt7nuZ/B7hw=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ba, err := readHtpasswd(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		user, passw string
+		ok          bool
+	}{
+		{"bob", "bpass", true}, {"sam", "spass", true},
+		{"bob", "spass", false}, {"sam", "bpass", false}, {"eve", "bpass", false},
+	} {
+		r, _ := http.NewRequest("POST", "/", nil)
+		r.SetBasicAuth(tc.user, tc.passw)
+		id, err := ba.authenticate(r)
+		if tc.ok {
+			if err != nil || id == nil || id.Name != "basic:"+tc.user {
+				t.Errorf("%s: got %v, %v", tc.user, id, err)
+			}
+			continue
+		}
+		// unknown users and bad passwords look the same
+		ae, ok := err.(authError)
+		if !ok || ae.Code != http.StatusUnauthorized || strings.Contains(ae.Msg, tc.user) {
+			t.Errorf("%s/%s: got %v, %#v", tc.user, tc.passw, id, err)
+		}
+	}
+	r, _ := http.NewRequest("POST", "/", nil)
+	if id, err := ba.authenticate(r); id != nil || err != nil {
+		t.Errorf("no credentials: got %v, %v", id, err)
+	}
+
+	for _, content := range []string{"nocolon\n", "joe:$apr1$x$y\n"} {
+		if err = ioutil.WriteFile(fn, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = readHtpasswd(fn); err == nil {
+			t.Errorf("%q: no error", content)
+		}
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	ta := tokenAuth{{Token: "s3cr3t", Name: "ci", Logger: "jenkins", Hostname: "ci-1"}}
+	for _, tc := range []struct {
+		header string
+		ok     bool
+		code   int
+	}{
+		{"Bearer s3cr3t", true, 0}, {"bearer  s3cr3t ", true, 0},
+		{"Bearer wrong", false, 401}, {"Basic czNjcjN0", false, 0}, {"", false, 0},
+	} {
+		r, _ := http.NewRequest("POST", "/", nil)
+		r.Header.Set("Authorization", tc.header)
+		id, err := ta.authenticate(r)
+		if tc.ok {
+			if err != nil || id == nil || id.Name != "token:ci" || id.Logger != "jenkins" || id.Hostname != "ci-1" {
+				t.Errorf("%q: got %v, %v", tc.header, id, err)
+			}
+			continue
+		}
+		if id != nil {
+			t.Errorf("%q: got %v", tc.header, id)
+		}
+		if ae, _ := err.(authError); ae.Code != tc.code {
+			t.Errorf("%q: got %#v, wanted %d", tc.header, err, tc.code)
+		}
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	ha := hmacAuth{keys: map[string][]byte{"backup": []byte("k3y")}, maxSkew: time.Minute}
+	sign := func(key, ts, body string) *http.Request {
+		r, _ := http.NewRequest("POST", "/?logger=x", strings.NewReader(body))
+		mac := hmac.New(sha256.New, []byte(key))
+		fmt.Fprintf(mac, "%s\n%s\n%s\n%s", ts, "POST", "/?logger=x", body)
+		r.Header.Set(HMACKeyHeader, "backup")
+		r.Header.Set(HMACTimestampHeader, ts)
+		r.Header.Set(HMACSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		return r
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	r := sign("k3y", now, "the body")
+	id, err := ha.authenticate(r)
+	if err != nil || id == nil || id.Name != "hmac:backup" {
+		t.Fatalf("got %v, %v", id, err)
+	}
+	// the body is still there for the handler
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != "the body" {
+		t.Errorf("body is %q after the check", body)
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	for _, tc := range []struct {
+		name, key, ts string
+		tamper        func(*http.Request)
+	}{
+		{"bad key", "other", now, nil},
+		{"old", "k3y", old, nil},
+		{"bad ts", "k3y", "yesterday", nil},
+		{"no sig", "k3y", now, func(r *http.Request) { r.Header.Del(HMACSignatureHeader) }},
+		{"unknown", "k3y", now, func(r *http.Request) { r.Header.Set(HMACKeyHeader, "x") }},
+		{"other body", "k3y", now, func(r *http.Request) { r.Body = ioutil.NopCloser(strings.NewReader("b")) }},
+	} {
+		r := sign(tc.key, tc.ts, "the body")
+		if tc.tamper != nil {
+			tc.tamper(r)
+		}
+		if id, err := ha.authenticate(r); id != nil || err == nil {
+			t.Errorf("%s: got %v, %v", tc.name, id, err)
+		}
+	}
+}
+
+func TestAuthChallenge(t *testing.T) {
+	hsi, _ := newTestInput(1)
+	hsi.auths = []authenticator{basicAuth{}, tokenAuth{{Token: "x", Name: "x"}}}
+
+	for _, tc := range []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{`Basic realm="hekad"`, `Bearer realm="hekad"`}},
+		{"Bearer y", []string{`Bearer realm="hekad"`}},
+		{"Basic Ym9iOnBhc3M=", []string{`Basic realm="hekad"`}},
+	} {
+		r, _ := http.NewRequest("POST", "/", strings.NewReader("x"))
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		if got := w.Header()["Www-Authenticate"]; w.Code != 401 || strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%q: got %d %q, wanted %q", tc.header, w.Code, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// batchResult is the response to a batch request
+type batchResult struct {
+	Accepted int `json:"accepted"`
+	// Rejected holds the (1-based) numbers of the rejected lines
+	Rejected []int    `json:"rejected"`
+	Errors   []string `json:"errors,omitempty"`
+	// UUIDs are the uuids of the accepted messages, in ack mode
+	UUIDs []string `json:"uuids,omitempty"`
+}
+
+// handleBatch splits the body into parts by split, and injects every
+// non-empty part as a separate message set by parse, with tmpl's values
+// as defaults. A split error rejects the rest of the body.
+func (hsi *HTTPSimpleInput) handleBatch(w http.ResponseWriter, req request, tmpl *message.Message,
+	split func([]byte) ([][]byte, error), parse func(*message.Message, []byte) error) {
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		atomic.AddInt64(&hsi.stats.Rejected, 1)
+		w.WriteHeader(bodyErrCode(err))
+		fmt.Fprintf(w, "error reading body: %s\n", err)
+		return
+	}
+	// every line gets its own uuid and payload
+	tmpl.Uuid, tmpl.Payload = nil, nil
+
+	res := batchResult{Rejected: []int{}}
+	parts, splitErr := split(body)
+	if splitErr != nil {
+		atomic.AddInt64(&hsi.stats.DecodeFailed, 1)
+	}
+	for n, line := range parts {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		msg := message.CopyMessage(tmpl)
+		if err = parse(msg, line); err != nil {
+			atomic.AddInt64(&hsi.stats.DecodeFailed, 1)
+			atomic.AddInt64(&hsi.stats.Rejected, 1)
+			res.Rejected = append(res.Rejected, n+1)
+			res.Errors = append(res.Errors, fmt.Sprintf("%d: %s", n+1, err))
+			continue
+		}
+		var id string
+		if id, err = hsi.injectMessage(w, msg, req); err != nil {
+			if _, ok := err.(schemaError); ok {
+				atomic.AddInt64(&hsi.stats.Rejected, 1)
+				res.Rejected = append(res.Rejected, n+1)
+				res.Errors = append(res.Errors, fmt.Sprintf("%d: %s", n+1, err))
+				continue
+			}
+			// the pipeline is saturated or stopping, reject the rest
+			atomic.AddInt64(&hsi.stats.Rejected, 1)
+			res.Rejected = append(res.Rejected, n+1)
+			res.Errors = append(res.Errors, fmt.Sprintf("%d-: %s", n+1, err))
+			break
+		}
+		res.Accepted++
+		if hsi.ack {
+			res.UUIDs = append(res.UUIDs, id)
+		}
+	}
+	if splitErr != nil && err != errNoPack && err != errStopping && err != errNotInjected {
+		err = splitErr
+		atomic.AddInt64(&hsi.stats.Rejected, 1)
+		res.Rejected = append(res.Rejected, len(parts)+1)
+		res.Errors = append(res.Errors, fmt.Sprintf("%d-: %s", len(parts)+1, err))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if res.Accepted == 0 && len(res.Rejected) > 0 {
+		if err == errNoPack || err == errStopping || err == errNotInjected {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else if _, ok := err.(schemaError); ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		} else {
+			w.WriteHeader(400)
+		}
+	} else {
+		w.WriteHeader(201)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// injectMessage finishes and checks the message, and injects it in a free pack.
+// Returns the uuid of the message, which must not be read after this.
+// If it could not be injected, Retry-After is set: the client may retry it.
+func (hsi *HTTPSimpleInput) injectMessage(w http.ResponseWriter, msg *message.Message, req request) (string, error) {
+	hsi.finishMessage(msg, req)
+	if err := hsi.checkMessage(msg); err != nil {
+		return "", err
+	}
+	pack, err := hsi.getPack()
+	if err == errStopping {
+		setRetryAfter(w, hsi.drainTimeout)
+		return "", err
+	} else if err != nil {
+		atomic.AddInt64(&hsi.stats.PackTimeouts, 1)
+		setRetryAfter(w, time.Second)
+		return "", err
+	}
+	pack.Message = msg
+	pack.Decoded = true
+	id, err := hsi.inject(pack, req.route)
+	if err == errStopping {
+		setRetryAfter(w, hsi.drainTimeout)
+		return "", err
+	} else if err != nil {
+		setRetryAfter(w, time.Second)
+		return "", err
+	}
+	atomic.AddInt64(&hsi.stats.Accepted, 1)
+	return id, nil
+}
+
+// splitLines splits the body by the batch delimiter (and a \r before \n)
+func (hsi *HTTPSimpleInput) splitLines(body []byte) ([][]byte, error) {
+	lines := bytes.Split(body, hsi.batchDelim)
+	if hsi.batchDelim[len(hsi.batchDelim)-1] == '\n' {
+		for i, line := range lines {
+			lines[i] = bytes.TrimSuffix(line, []byte{'\r'})
+		}
+	}
+	return lines, nil
+}
+
+// parseLine sets the message from one line of a batch:
+// a JSON object is mapped onto the message, anything else is the payload
+func (hsi *HTTPSimpleInput) parseLine(msg *message.Message, line []byte, isJSON bool) error {
+	if isJSON {
+		return hsi.parseJSON(msg, line)
+	}
+	msg.SetPayload(string(line))
+	return nil
+}
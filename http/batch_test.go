@@ -0,0 +1,76 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestInput returns an input with a pack supply of n, and the channel
+// the handler sends the filled packs to
+func newTestInput(n int) (*HTTPSimpleInput, chan *pipeline.PipelinePack) {
+	hsi := &HTTPSimpleInput{
+		packs: make(chan *pipeline.PipelinePack, n),
+		input: make(chan *pipeline.PipelinePack, n),
+	}
+	for i := 0; i < n; i++ {
+		hsi.packs <- &pipeline.PipelinePack{Message: new(message.Message)}
+	}
+	return hsi, hsi.input
+}
+
+func TestBatch(t *testing.T) {
+	hsi, input := newTestInput(4)
+	hsi.batchDelim = []byte{'\n'}
+
+	r, _ := http.NewRequest("POST", "/?logger=batcher&severity=5&color=red",
+		strings.NewReader("first\r\nsecond\n\n  \nthird\n"))
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 201 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	var res batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("cannot parse response %q: %s", w.Body, err)
+	}
+	if res.Accepted != 3 || len(res.Rejected) != 0 {
+		t.Errorf("got %+v, wanted 3 accepted", res)
+	}
+	close(input)
+	uuids := make(map[string]bool, 3)
+	var payloads []string
+	for pack := range input {
+		m := pack.Message
+		payloads = append(payloads, m.GetPayload())
+		if m.GetLogger() != "batcher" || m.GetSeverity() != 5 {
+			t.Errorf("defaults are not applied: %s", m)
+		}
+		if f := m.FindFirstField("color"); f == nil || f.GetValue() != "red" {
+			t.Errorf("field color is missing from %s", m)
+		}
+		uuids[string(m.Uuid)] = true
+	}
+	if strings.Join(payloads, ",") != "first,second,third" {
+		t.Errorf("got payloads %q", payloads)
+	}
+	if len(uuids) != 3 {
+		t.Errorf("uuids are not unique: %d", len(uuids))
+	}
+}
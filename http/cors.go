@@ -0,0 +1,108 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSConfig holds the Cross-Origin Resource Sharing settings
+type CORSConfig struct {
+	// AllowedOrigins are the origins (as "https://example.com") allowed
+	// to post from browsers; "*" allows any
+	AllowedOrigins []string `toml:"allowed_origins"`
+	// AllowedHeaders are allowed besides Content-Type, Content-Encoding,
+	// Authorization and Idempotency-Key
+	AllowedHeaders []string `toml:"allowed_headers"`
+	// AllowCredentials allows cookies and Basic authentication
+	AllowCredentials bool `toml:"allow_credentials"`
+	// MaxAge is the number of seconds the preflight result can be cached
+	MaxAge int `toml:"max_age"`
+}
+
+// pixelGIF is a transparent 1x1 GIF
+var pixelGIF = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// cors is the parsed CORSConfig
+type cors struct {
+	CORSConfig
+	any     bool
+	origins map[string]bool
+	headers string
+}
+
+// newCORS returns the CORS settings, or nil if no origin is allowed
+func newCORS(cc CORSConfig) *cors {
+	if len(cc.AllowedOrigins) == 0 {
+		return nil
+	}
+	c := &cors{CORSConfig: cc, origins: make(map[string]bool, len(cc.AllowedOrigins))}
+	for _, o := range cc.AllowedOrigins {
+		if o == "*" {
+			c.any = true
+		}
+		c.origins[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+	headers := append([]string{"Content-Type", "Content-Encoding", "Authorization", "Idempotency-Key"},
+		cc.AllowedHeaders...)
+	c.headers = strings.Join(headers, ", ")
+	return c
+}
+
+// handle sets the CORS headers for an allowed origin, and answers the
+// preflight requests - returns true if the request is answered.
+func (c *cors) handle(w http.ResponseWriter, r *http.Request) bool {
+	if c == nil {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if origin == "" || !c.any && !c.origins[strings.ToLower(origin)] {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+			return true
+		}
+		return false
+	}
+	if c.any && !c.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", "Retry-After")
+		return false
+	}
+	h.Set("Access-Control-Allow-Methods", "POST, PUT, GET")
+	h.Set("Access-Control-Allow-Headers", c.headers)
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// writePixel answers an image beacon
+func writePixel(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(200)
+	w.Write(pixelGIF)
+}
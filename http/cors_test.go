@@ -0,0 +1,78 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORSBeacon(t *testing.T) {
+	hsi, input := newTestInput(2)
+	hsi.cors = newCORS(CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: 600})
+	hsi.beaconPath = "/beacon"
+	hsi.auths = []authenticator{tokenAuth{{Token: "x", Name: "x"}}}
+
+	do := func(method, path, origin, ct, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if ct != "" {
+			r.Header.Set("Content-Type", ct)
+		}
+		if method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w
+	}
+
+	w := do("OPTIONS", "/", "https://app.example.com", "", "")
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("preflight: got %d %v", w.Code, w.Header())
+	}
+	if w = do("OPTIONS", "/", "https://evil.example.com", "", ""); w.Code != 403 {
+		t.Errorf("preflight from other origin: got %d", w.Code)
+	}
+
+	w = do("POST", "/beacon", "https://app.example.com", "text/plain;charset=UTF-8",
+		`{"type": "js.error", "message": "x is undefined"}`)
+	if w.Code != 201 || w.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Fatalf("beacon: got %d %v: %s", w.Code, w.Header(), w.Body)
+	}
+	m := (<-input).Message
+	if f := m.FindFirstField("message"); m.GetType() != "js.error" || f == nil {
+		t.Errorf("beacon: got %s", m)
+	}
+
+	w = do("GET", "/beacon?type=js.error&page=home", "", "", "")
+	if w.Code != 200 || w.Header().Get("Content-Type") != "image/gif" {
+		t.Fatalf("pixel: got %d %v", w.Code, w.Header())
+	}
+	if _, err := gif.Decode(w.Body); err != nil {
+		t.Errorf("pixel is not a GIF: %s", err)
+	}
+	if m = (<-input).Message; m.FindFirstField("page") == nil {
+		t.Errorf("pixel: got %s", m)
+	}
+
+	// other paths still need authentication
+	if w = do("POST", "/", "https://app.example.com", "text/plain", "x"); w.Code != 401 {
+		t.Errorf("unauthenticated post: got %d", w.Code)
+	}
+}
@@ -0,0 +1,124 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DecoderMapping maps the requests with a content type (and optionally
+// a path) to a decoder registered in hekad
+type DecoderMapping struct {
+	// ContentType is the media type (without parameters); "*" matches any
+	ContentType string `toml:"content_type"`
+	// Path is matched exactly, or as a prefix if it ends with "*";
+	// empty matches any
+	Path    string `toml:"path"`
+	Decoder string `toml:"decoder"`
+}
+
+// defaultDecoderMappings are used after the configured ones
+var defaultDecoderMappings = []DecoderMapping{
+	{ContentType: "application/json", Decoder: "JSON"},
+	{ContentType: "application/x-protobuf", Decoder: "PROTOCOL_BUFFER"},
+}
+
+// decoderMapping is the parsed DecoderMapping
+type decoderMapping struct {
+	DecoderMapping
+	prefix bool
+}
+
+// newDecoderMappings checks the mappings and returns them
+func newDecoderMappings(dms []DecoderMapping) ([]decoderMapping, error) {
+	mappings := make([]decoderMapping, 0, len(dms))
+	for _, dm := range dms {
+		if dm.ContentType == "" || dm.Decoder == "" {
+			return nil, fmt.Errorf("decoder mapping needs content_type and decoder (%+v)", dm)
+		}
+		if dm.Path != "" && dm.Path[0] != '/' {
+			return nil, fmt.Errorf("decoder mapping path %q must start with /", dm.Path)
+		}
+		m := decoderMapping{DecoderMapping: dm}
+		m.ContentType = strings.ToLower(m.ContentType)
+		if strings.HasSuffix(dm.Path, "*") {
+			m.Path, m.prefix = dm.Path[:len(dm.Path)-1], true
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// isNative reports whether the input parses the media type itself
+func isNative(mediaType string, flatJSON bool) bool {
+	switch mediaType {
+	case "", "text/plain", "application/x-www-form-urlencoded", "multipart/form-data":
+		return true
+	case "application/logplex-1", "application/syslog", "text/syslog":
+		return true
+	case "application/json":
+		return flatJSON
+	}
+	return false
+}
+
+// decoderFor returns the name of the decoder for the media type and path:
+// the first matching mapping, then the default mappings (but flat JSON is
+// parsed by the input), or for the types not parsed by the input, the
+// default decoder. Empty means no decoder.
+func (hsi *HTTPSimpleInput) decoderFor(mediaType, path string) string {
+	for _, m := range hsi.decoderMappings {
+		if m.ContentType != "*" && m.ContentType != mediaType {
+			continue
+		}
+		if m.Path == "" || m.Path == path || m.prefix && strings.HasPrefix(path, m.Path) {
+			return m.Decoder
+		}
+	}
+	for _, dm := range defaultDecoderMappings {
+		if dm.ContentType == mediaType && !(hsi.flatJSON && mediaType == "application/json") {
+			return dm.Decoder
+		}
+	}
+	if !isNative(mediaType, hsi.flatJSON) {
+		return hsi.defaultDecoder
+	}
+	return ""
+}
+
+// uncheckedDecoders returns the decoders which may get the bodies, whose
+// messages are not checked against the schemas: all of them, but in ack mode
+// the JSON and protobuf bodies are decoded by the input.
+func (hsi *HTTPSimpleInput) uncheckedDecoders() []string {
+	names := make(map[string]bool, len(hsi.decoderMappings)+3)
+	for _, m := range hsi.decoderMappings {
+		names[m.Decoder] = true
+	}
+	for _, dm := range defaultDecoderMappings {
+		if !(hsi.flatJSON && dm.ContentType == "application/json") {
+			names[dm.Decoder] = true
+		}
+	}
+	if hsi.defaultDecoder != "" {
+		names[hsi.defaultDecoder] = true
+	}
+	decoders := make([]string, 0, len(names))
+	for k := range names {
+		if !hsi.ack || k != "JSON" && k != "PROTOCOL_BUFFER" {
+			decoders = append(decoders, k)
+		}
+	}
+	sort.Strings(decoders)
+	return decoders
+}
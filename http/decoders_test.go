@@ -0,0 +1,117 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/mozilla-services/heka/pipeline"
+
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecoderFor(t *testing.T) {
+	hsi := &HTTPSimpleInput{defaultDecoder: "Fallback"}
+	var err error
+	if hsi.decoderMappings, err = newDecoderMappings([]DecoderMapping{
+		{ContentType: "text/plain", Path: "/rsyslog/*", Decoder: "RsyslogDecoder"},
+		{ContentType: "application/x-ndjson", Decoder: "NdjsonMulti"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range []struct {
+		flat            bool
+		mediaType, path string
+		decoder         string
+	}{
+		{false, "text/plain", "/rsyslog/host1", "RsyslogDecoder"},
+		{false, "text/plain", "/other", ""},
+		{false, "application/x-ndjson", "/", "NdjsonMulti"},
+		{false, "application/json", "/", "JSON"},
+		{true, "application/json", "/", ""},
+		{false, "application/x-protobuf", "/", "PROTOCOL_BUFFER"},
+		{false, "application/octet-stream", "/", "Fallback"},
+		{false, "multipart/form-data", "/", ""},
+		{false, "", "/", ""},
+	} {
+		hsi.flatJSON = tc.flat
+		if got := hsi.decoderFor(tc.mediaType, tc.path); got != tc.decoder {
+			t.Errorf("%d. %s %s: got %q, wanted %q", i, tc.mediaType, tc.path, got, tc.decoder)
+		}
+	}
+	if _, err = newDecoderMappings([]DecoderMapping{{ContentType: "text/plain"}}); err == nil {
+		t.Error("mapping without decoder should fail")
+	}
+}
+
+// testDecoder is a DecoderRunner which decodes its packs in a goroutine
+type testDecoder struct {
+	pipeline.DecoderRunner
+	in chan *pipeline.PipelinePack
+}
+
+func (td testDecoder) InChan() chan *pipeline.PipelinePack { return td.in }
+
+func TestUncheckedDecoders(t *testing.T) {
+	mappings, err := newDecoderMappings([]DecoderMapping{
+		{ContentType: "application/x-ndjson", Decoder: "NdjsonMulti"},
+		{ContentType: "application/json", Path: "/heka", Decoder: "JSON"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range []struct {
+		hsi  *HTTPSimpleInput
+		want string
+	}{
+		{&HTTPSimpleInput{}, "JSON PROTOCOL_BUFFER"},
+		{&HTTPSimpleInput{flatJSON: true}, "PROTOCOL_BUFFER"},
+		{&HTTPSimpleInput{ack: true}, ""},
+		{&HTTPSimpleInput{ack: true, flatJSON: true, decoderMappings: mappings, defaultDecoder: "Fallback"},
+			"Fallback NdjsonMulti"},
+		{&HTTPSimpleInput{flatJSON: true, decoderMappings: mappings}, "JSON NdjsonMulti PROTOCOL_BUFFER"},
+	} {
+		if got := strings.Join(tc.hsi.uncheckedDecoders(), " "); got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestAckDecoder(t *testing.T) {
+	hsi, _ := newTestInput(2)
+	hsi.ack = true
+	hsi.done = make(chan struct{})
+	defer close(hsi.done)
+	hsi.decoderMappings, _ = newDecoderMappings([]DecoderMapping{{ContentType: "text/csv", Decoder: "CSV"}})
+	td := testDecoder{in: make(chan *pipeline.PipelinePack)}
+	hsi.DecoderRunner = func(name string) (pipeline.DecoderRunner, bool) { return td, name == "CSV" }
+	decoded := make(chan string)
+	go func() {
+		pack := <-td.in
+		pack.Message.SetPayload(string(pack.MsgBytes))
+		pack.Message.SetUuid(uuid.NewRandom())
+		decoded <- pack.Message.GetPayload()
+	}()
+
+	r, _ := http.NewRequest("POST", "/", strings.NewReader("a,b"))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 202 || w.Body.Len() != 0 {
+		t.Errorf("got %d: %q, wanted 202 without uuid", w.Code, w.Body)
+	}
+	if p := <-decoded; p != "a,b" {
+		t.Errorf("decoded %q", p)
+	}
+}
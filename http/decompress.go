@@ -0,0 +1,155 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/klauspost/compress/zstd"
+
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxDecompressedSize is the default limit of a decompressed body
+const DefaultMaxDecompressedSize = 32 << 20
+
+// errTooLarge is returned when reading more than the allowed size
+var errTooLarge = errors.New("request body too large")
+
+// unsupportedEncodingError is returned for an unknown Content-Encoding
+type unsupportedEncodingError string
+
+func (e unsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported Content-Encoding %q", string(e))
+}
+
+// bodyErrCode returns the HTTP status for an error reading the body
+func bodyErrCode(err error) int {
+	if err == errTooLarge || err == zstd.ErrDecoderSizeExceeded {
+		return http.StatusRequestEntityTooLarge
+	}
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return http.StatusRequestEntityTooLarge
+	}
+	if _, ok := err.(unsupportedEncodingError); ok {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// limitedReader returns errTooLarge after reading more than n bytes
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.n < 0 {
+		return 0, errTooLarge
+	}
+	if int64(len(p)) > lr.n+1 {
+		p = p[:lr.n+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.n -= int64(n)
+	if lr.n < 0 {
+		return n + int(lr.n), errTooLarge
+	}
+	return n, err
+}
+
+// decompressedBody reads the decompressed stream, and closes both the
+// decompressor and the original body
+type decompressedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (db decompressedBody) Close() error {
+	var err error
+	for i := len(db.closers) - 1; i >= 0; i-- {
+		if e := db.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// closerFunc makes a func() an io.Closer
+type closerFunc func()
+
+func (cf closerFunc) Close() error {
+	cf()
+	return nil
+}
+
+// decompressBody returns the request's body decoded according to its
+// Content-Encoding (gzip, deflate, zstd, or a list of them), limited to
+// maxSize bytes.
+func decompressBody(r *http.Request, maxSize int64) (io.ReadCloser, error) {
+	ce := strings.TrimSpace(r.Header.Get("Content-Encoding"))
+	if r.Body == nil || ce == "" || strings.EqualFold(ce, "identity") {
+		return r.Body, nil
+	}
+	encodings := strings.Split(ce, ",")
+	db := decompressedBody{Reader: r.Body, closers: []io.Closer{r.Body}}
+	// the encodings are listed in the order they were applied
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch enc := strings.ToLower(strings.TrimSpace(encodings[i])); enc {
+		case "identity":
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(db.Reader)
+			if err != nil {
+				db.Close()
+				return nil, fmt.Errorf("error reading gzip body: %s", err)
+			}
+			db.Reader, db.closers = zr, append(db.closers, zr)
+		case "deflate":
+			// should be zlib, but some clients send raw deflate
+			br := bufio.NewReader(db.Reader)
+			if head, err := br.Peek(2); err == nil && isZlibHeader(head) {
+				zr, err := zlib.NewReader(br)
+				if err != nil {
+					db.Close()
+					return nil, fmt.Errorf("error reading deflate body: %s", err)
+				}
+				db.Reader, db.closers = zr, append(db.closers, zr)
+			} else {
+				fr := flate.NewReader(br)
+				db.Reader, db.closers = fr, append(db.closers, fr)
+			}
+		case "zstd":
+			zr, err := zstd.NewReader(db.Reader, zstd.WithDecoderMaxMemory(uint64(maxSize)))
+			if err != nil {
+				db.Close()
+				return nil, fmt.Errorf("error reading zstd body: %s", err)
+			}
+			db.Reader, db.closers = zr, append(db.closers, closerFunc(zr.Close))
+		default:
+			db.Close()
+			return nil, unsupportedEncodingError(enc)
+		}
+	}
+	db.Reader = &limitedReader{r: db.Reader, n: maxSize}
+	return db, nil
+}
+
+// isZlibHeader reports whether the two bytes are a valid zlib header
+func isZlibHeader(head []byte) bool {
+	return head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0
+}
@@ -0,0 +1,77 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/klauspost/compress/zstd"
+
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDecompressBody(t *testing.T) {
+	text := strings.Repeat("abraka dabra\n", 100)
+	for _, tc := range []struct {
+		name, enc string
+		newWriter func(io.Writer) io.WriteCloser
+	}{
+		{"gzip", "gzip", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+		{"zlib", "deflate", func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+		{"raw deflate", "deflate", func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		}},
+		{"zstd", "zstd", func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		}},
+	} {
+		enc := tc.name
+		var buf bytes.Buffer
+		w := tc.newWriter(&buf)
+		io.WriteString(w, text)
+		w.Close()
+		for _, limit := range []int64{int64(len(text)), 100} {
+			r, _ := http.NewRequest("POST", "/", bytes.NewReader(buf.Bytes()))
+			r.Header.Set("Content-Encoding", tc.enc)
+			body, err := decompressBody(r, limit)
+			if err != nil {
+				t.Errorf("%s: %s", enc, err)
+				continue
+			}
+			got, err := ioutil.ReadAll(body)
+			body.Close()
+			if limit < int64(len(text)) {
+				if bodyErrCode(err) != http.StatusRequestEntityTooLarge || len(got) > int(limit) {
+					t.Errorf("%s: wanted too large after %d bytes, got %d (%v)", enc, limit, len(got), err)
+				}
+				continue
+			}
+			if err != nil || string(got) != text {
+				t.Errorf("%s: got %q (%v)", enc, got, err)
+			}
+		}
+	}
+
+	r, _ := http.NewRequest("POST", "/", strings.NewReader("x"))
+	r.Header.Set("Content-Encoding", "br")
+	if _, err := decompressBody(r, 10); bodyErrCode(err) != http.StatusUnsupportedMediaType {
+		t.Errorf("br: got %v", err)
+	}
+}
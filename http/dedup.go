@@ -0,0 +1,163 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDedupSize is the default number of remembered keys
+const DefaultDedupSize = 100000
+
+// errInProgress is returned for a replay of a request still in progress
+var errInProgress = errors.New("a request with the same key is in progress")
+
+// DedupConfig holds the settings of the deduplication of the requests
+// by their Idempotency-Key header or their message's uuid
+type DedupConfig struct {
+	// Window is how long an accepted key is remembered (e.g. "10m");
+	// empty means no deduplication
+	Window string `toml:"window"`
+	// Size is the maximal number of remembered keys
+	Size int `toml:"size"`
+}
+
+// dedupEntry is the remembered result of a request
+type dedupEntry struct {
+	key         string
+	accepted    time.Time
+	done        bool
+	contentType string
+	body        []byte
+}
+
+// deduper remembers the results of the accepted requests for a window,
+// bounded by size, the oldest evicted first
+type deduper struct {
+	window time.Duration
+	size   int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// newDeduper returns a deduper, or nil if window is zero
+func newDeduper(window time.Duration, size int) *deduper {
+	if window <= 0 {
+		return nil
+	}
+	if size <= 0 {
+		size = DefaultDedupSize
+	}
+	return &deduper{window: window, size: size,
+		entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// reserve returns the entry of key: a fresh pending one (and true) if key is
+// unknown, or the remembered one (and false).
+func (dd *deduper) reserve(key string) (*dedupEntry, bool) {
+	now := time.Now()
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	for e := dd.order.Front(); e != nil; e = dd.order.Front() {
+		if dd.order.Len() < dd.size && now.Sub(e.Value.(*dedupEntry).accepted) < dd.window {
+			break
+		}
+		dd.order.Remove(e)
+		delete(dd.entries, e.Value.(*dedupEntry).key)
+	}
+	if e, ok := dd.entries[key]; ok {
+		ent := *e.Value.(*dedupEntry)
+		return &ent, false
+	}
+	ent := &dedupEntry{key: key, accepted: now}
+	dd.entries[key] = dd.order.PushBack(ent)
+	return ent, true
+}
+
+// complete stores the result of the accepted request
+func (dd *deduper) complete(ent *dedupEntry, contentType string, body []byte) {
+	dd.mu.Lock()
+	ent.contentType, ent.body, ent.done = contentType, body, true
+	dd.mu.Unlock()
+}
+
+// release forgets the key of a failed request, so it can be retried
+func (dd *deduper) release(ent *dedupEntry) {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	if e, ok := dd.entries[ent.key]; ok && e.Value.(*dedupEntry) == ent {
+		dd.order.Remove(e)
+		delete(dd.entries, ent.key)
+	}
+}
+
+// dedupWriter records the response for the deduper
+type dedupWriter struct {
+	http.ResponseWriter
+	code int
+	buf  bytes.Buffer
+}
+
+func (dw *dedupWriter) WriteHeader(code int) {
+	if dw.code == 0 {
+		dw.code = code
+	}
+	dw.ResponseWriter.WriteHeader(code)
+}
+
+func (dw *dedupWriter) Write(p []byte) (int, error) {
+	if dw.code == 0 {
+		dw.code = 200
+	}
+	dw.buf.Write(p)
+	return dw.ResponseWriter.Write(p)
+}
+
+// dedup checks the key (scoped by the identity name): for a known key, it
+// replays the original response with 200 (or 409 if that is still in
+// progress) and returns true. Otherwise it wraps *w to record the response,
+// and the returned finish func remembers it if it was a success - but not
+// a partial one, with Retry-After for the rejected part.
+func (hsi *HTTPSimpleInput) dedup(w *http.ResponseWriter, idName, key string) (func(), bool) {
+	ent, fresh := hsi.deduper.reserve(idName + "\x00" + key)
+	if !fresh {
+		atomic.AddInt64(&hsi.stats.Duplicates, 1)
+		if !ent.done {
+			setRetryAfter(*w, time.Second)
+			(*w).WriteHeader(http.StatusConflict)
+			(*w).Write([]byte(errInProgress.Error() + "\n"))
+			return nil, true
+		}
+		(*w).Header().Set("Content-Type", ent.contentType)
+		(*w).Header().Set("Idempotent-Replayed", "true")
+		(*w).WriteHeader(200)
+		(*w).Write(ent.body)
+		return nil, true
+	}
+	dw := &dedupWriter{ResponseWriter: *w}
+	*w = dw
+	return func() {
+		if dw.code >= 200 && dw.code < 300 && dw.Header().Get("Retry-After") == "" {
+			hsi.deduper.complete(ent, dw.Header().Get("Content-Type"), dw.buf.Bytes())
+		} else {
+			hsi.deduper.release(ent)
+		}
+	}, false
+}
@@ -0,0 +1,103 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	hsi, input := newTestInput(4)
+	hsi.deduper = newDeduper(time.Minute, 2)
+
+	post := func(query, key string) int {
+		r, _ := http.NewRequest("POST", "/?payload=x"+query, nil)
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w.Code
+	}
+	for i, tc := range []struct {
+		query, key string
+		code       int
+	}{
+		{"&uuid=aaaa", "", 201},
+		{"&uuid=aaaa", "", 200},
+		{"", "k1", 201},
+		{"&uuid=bbbb", "k1", 200},
+		{"&uuid=bbbb", "", 201},
+		// evicted by size
+		{"&uuid=aaaa", "", 201},
+	} {
+		if code := post(tc.query, tc.key); code != tc.code {
+			t.Errorf("%d. got %d, wanted %d", i, code, tc.code)
+		}
+	}
+	if len(input) != 4 {
+		t.Errorf("got %d messages, wanted 4", len(input))
+	}
+	if s := hsi.stats.snapshot(); s.Duplicates != 2 {
+		t.Errorf("got %d duplicates, wanted 2", s.Duplicates)
+	}
+
+	// a partly accepted batch is not remembered, its retry is injected
+	hsi, input = newTestInput(3)
+	<-hsi.packs
+	<-hsi.packs
+	hsi.deduper = newDeduper(time.Minute, 0)
+	hsi.batchDelim = []byte{'\n'}
+	batch := func() *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/", strings.NewReader("a\nb\n"))
+		r.Header.Set("Idempotency-Key", "k2")
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w
+	}
+	if w := batch(); w.Code != 201 || w.Header().Get("Retry-After") == "" || !strings.Contains(w.Body.String(), `"rejected":[2]`) {
+		t.Errorf("partial batch: got %d %v: %s", w.Code, w.Header(), w.Body)
+	}
+	<-input
+	hsi.packs <- &pipeline.PipelinePack{Message: new(message.Message)}
+	hsi.packs <- &pipeline.PipelinePack{Message: new(message.Message)}
+	if w := batch(); w.Code != 201 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retried batch: got %d %v: %s", w.Code, w.Header(), w.Body)
+	}
+	if len(input) != 2 {
+		t.Errorf("retry: got %d messages, wanted 2", len(input))
+	}
+	if w := batch(); w.Code != 200 || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replayed batch: got %d %v: %s", w.Code, w.Header(), w.Body)
+	}
+
+	// failed requests can be retried
+	dd := newDeduper(time.Minute, 0)
+	ent, fresh := dd.reserve("x")
+	if !fresh {
+		t.Fatal("first reserve should be fresh")
+	}
+	if _, fresh = dd.reserve("x"); fresh {
+		t.Error("pending key should not be fresh")
+	}
+	dd.release(ent)
+	if _, fresh = dd.reserve("x"); !fresh {
+		t.Error("released key should be fresh")
+	}
+}
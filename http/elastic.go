@@ -0,0 +1,263 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// elasticVersion is the Elasticsearch version reported to the clients
+const elasticVersion = "8.11.0"
+
+// elasticRequest returns the kind of the Elasticsearch API request:
+// "info" for the root, "bulk" for _bulk (with the index from the path),
+// or "" if it is not one
+func (hsi *HTTPSimpleInput) elasticRequest(r *http.Request) (kind, index string) {
+	if hsi.elasticPath == "" || !strings.HasPrefix(r.URL.Path, hsi.elasticPath) {
+		return "", ""
+	}
+	rest := r.URL.Path[len(hsi.elasticPath):]
+	if rest != "" && rest[0] != '/' && !strings.HasSuffix(hsi.elasticPath, "/") {
+		// "/esx" is not under "/es"
+		return "", ""
+	}
+	rest = strings.Trim(rest, "/")
+	switch {
+	case rest == "" && (r.Method == "GET" || r.Method == "HEAD"):
+		return "info", ""
+	case rest == "_bulk":
+		return "bulk", ""
+	case strings.HasSuffix(rest, "/_bulk") && strings.Count(rest, "/") == 1:
+		return "bulk", strings.TrimSuffix(rest, "/_bulk")
+	}
+	return "", ""
+}
+
+// serveElasticInfo answers the root request, which the clients use to
+// check the version
+func serveElasticInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.WriteHeader(200)
+	if r.Method == "HEAD" {
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":         "hekad",
+		"cluster_name": "heka",
+		"version": map[string]interface{}{
+			"number":                              elasticVersion,
+			"build_flavor":                        "default",
+			"minimum_wire_compatibility_version":  "7.17.0",
+			"minimum_index_compatibility_version": "7.0.0",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+// elasticItem is the result of one action of a bulk request
+type elasticItem struct {
+	Index  string        `json:"_index"`
+	ID     string        `json:"_id,omitempty"`
+	Status int           `json:"status"`
+	Result string        `json:"result,omitempty"`
+	Error  *elasticError `json:"error,omitempty"`
+}
+
+type elasticError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// handleElasticBulk injects the documents of a _bulk request as messages,
+// with tmpl's values as defaults, and answers as Elasticsearch would.
+// The type of the messages is the index; index and create take the
+// document, update its "doc", delete is not supported.
+func (hsi *HTTPSimpleInput) handleElasticBulk(w http.ResponseWriter, req request,
+	tmpl *message.Message, defIndex string) {
+
+	start := time.Now()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		atomic.AddInt64(&hsi.stats.Rejected, 1)
+		w.WriteHeader(bodyErrCode(err))
+		fmt.Fprintf(w, "error reading body: %s\n", err)
+		return
+	}
+	tmpl.Uuid, tmpl.Payload = nil, nil
+
+	var items []map[string]elasticItem
+	var hasErrors bool
+	var injectErr error
+	reject := func(action string, item elasticItem, status int, typ string, err error) {
+		atomic.AddInt64(&hsi.stats.Rejected, 1)
+		item.Status, item.Error, hasErrors = status, &elasticError{Type: typ, Reason: err.Error()}, true
+		items = append(items, map[string]elasticItem{action: item})
+	}
+	lines := bytes.Split(body, []byte{'\n'})
+	for i := 0; i < len(lines); i++ {
+		line := bytes.TrimSpace(lines[i])
+		if len(line) == 0 {
+			continue
+		}
+		var actions map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err = json.Unmarshal(line, &actions); err != nil || len(actions) != 1 {
+			// the documents cannot be paired with their actions any more:
+			// the previous items stand, the rest is not processed
+			atomic.AddInt64(&hsi.stats.DecodeFailed, 1)
+			reject("index", elasticItem{Index: defIndex}, 400, "illegal_argument_exception",
+				fmt.Errorf("malformed action at line %d, the rest is skipped", i+1))
+			break
+		}
+		var action string
+		var item elasticItem
+		for k, v := range actions {
+			action, item = k, elasticItem{Index: v.Index, ID: v.ID}
+		}
+		if item.Index == "" {
+			item.Index = defIndex
+		}
+		if action == "delete" {
+			reject(action, item, 400, "illegal_argument_exception", errors.New("delete is not supported"))
+			continue
+		}
+		if i++; i >= len(lines) {
+			reject(action, item, 400, "illegal_argument_exception", errors.New("missing document"))
+			break
+		}
+		doc := bytes.TrimSpace(lines[i])
+		switch {
+		case action != "index" && action != "create" && action != "update":
+			reject(action, item, 400, "illegal_argument_exception", fmt.Errorf("unknown action %q", action))
+			continue
+		case injectErr != nil:
+			// the pipeline is saturated: the client should retry these
+			reject(action, item, 429, "es_rejected_execution_exception", injectErr)
+			continue
+		case item.Index == "":
+			reject(action, item, 400, "action_request_validation_exception", errors.New("index is missing"))
+			continue
+		}
+		if action == "update" {
+			var upd struct {
+				Doc json.RawMessage `json:"doc"`
+			}
+			if err = json.Unmarshal(doc, &upd); err != nil || len(upd.Doc) == 0 {
+				atomic.AddInt64(&hsi.stats.DecodeFailed, 1)
+				reject(action, item, 400, "action_request_validation_exception", errors.New("update needs a doc"))
+				continue
+			}
+			doc = upd.Doc
+		}
+		msg := message.CopyMessage(tmpl)
+		if err = hsi.parseElasticDoc(msg, doc, item); err != nil {
+			atomic.AddInt64(&hsi.stats.DecodeFailed, 1)
+			reject(action, item, 400, "document_parsing_exception", err)
+			continue
+		}
+		var id string
+		if id, injectErr = hsi.injectMessage(w, msg, req); injectErr != nil {
+			if _, ok := injectErr.(schemaError); ok {
+				reject(action, item, 400, "validation_exception", injectErr)
+				injectErr = nil
+				continue
+			}
+			reject(action, item, 429, "es_rejected_execution_exception", injectErr)
+			continue
+		}
+		if item.ID == "" {
+			item.ID = id
+		}
+		item.Status, item.Result = 201, "created"
+		if action == "update" {
+			item.Status, item.Result = 200, "updated"
+		}
+		items = append(items, map[string]elasticItem{action: item})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.WriteHeader(200)
+	if items == nil {
+		items = []map[string]elasticItem{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"took":   int64(time.Since(start) / time.Millisecond),
+		"errors": hasErrors,
+		"items":  items,
+	})
+}
+
+// parseElasticDoc maps the document onto the message: a string "message"
+// is the payload (else the document itself), "@timestamp" the timestamp,
+// and every other key a field, with nested objects flattened as in parseJSON.
+func (hsi *HTTPSimpleInput) parseElasticDoc(msg *message.Message, doc []byte, item elasticItem) error {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return fmt.Errorf("error parsing JSON object: %s", err)
+	}
+	values := make(map[string][]interface{}, len(obj))
+	flattenJSON(values, "", obj)
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		vs := values[k]
+		switch {
+		case k == "message" && len(vs) == 1:
+			if s, ok := vs[0].(string); ok {
+				msg.SetPayload(s)
+				continue
+			}
+		case k == "@timestamp" && len(vs) == 1:
+			ts, err := parseTimestamp(jsonStrings(vs)[0], hsi.timestampUnit)
+			if err != nil {
+				return fmt.Errorf("bad @timestamp: %s", err)
+			}
+			msg.SetTimestamp(ts)
+			continue
+		}
+		f, err := jsonField(k, vs)
+		if err != nil {
+			return err
+		}
+		msg.AddField(f)
+	}
+	if msg.Payload == nil {
+		msg.SetPayload(string(doc))
+	}
+	if msg.Type == nil {
+		msg.SetType(item.Index)
+	}
+	if item.ID != "" {
+		addStringField(msg, "_id", item.ID)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestElasticsearch(t *testing.T) {
+	hsi, input := newTestInput(2)
+	hsi.elasticPath = "/es"
+
+	r, _ := http.NewRequest("GET", "/es/", nil)
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 200 || w.Header().Get("X-Elastic-Product") != "Elasticsearch" ||
+		!strings.Contains(w.Body.String(), elasticVersion) {
+		t.Fatalf("info: got %d %v: %s", w.Code, w.Header(), w.Body)
+	}
+
+	body := `{"index": {"_id": "a1"}}
+{"message": "first", "@timestamp": "2023-11-14T22:13:20Z", "user": "joe"}
+{"delete": {"_id": "a0"}}
+{"create": {"_index": "audit"}}
+{"message": "second"}
+{"index": {}}
+{"message": "third"}
+`
+	r, _ = http.NewRequest("POST", "/es/logs/_bulk", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	w = httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 200 {
+		t.Fatalf("bulk: got %d: %s", w.Code, w.Body)
+	}
+	var res struct {
+		Errors bool                     `json:"errors"`
+		Items  []map[string]elasticItem `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("cannot parse response %q: %s", w.Body, err)
+	}
+	if !res.Errors || len(res.Items) != 4 {
+		t.Fatalf("bulk: got %s", w.Body)
+	}
+	for i, want := range []struct {
+		action, index string
+		status        int
+	}{{"index", "logs", 201}, {"delete", "logs", 400}, {"create", "audit", 201}, {"index", "logs", 429}} {
+		if it, ok := res.Items[i][want.action]; !ok || it.Index != want.index || it.Status != want.status {
+			t.Errorf("item %d: got %+v, wanted %+v", i, res.Items[i], want)
+		}
+	}
+	if id := res.Items[2]["create"].ID; len(id) != 36 {
+		t.Errorf("create: got id %q, wanted the uuid", id)
+	}
+
+	m := (<-input).Message
+	if m.GetPayload() != "first" || m.GetType() != "logs" || m.GetTimestamp() != 1700000000000000000 {
+		t.Errorf("first: got %s", m)
+	}
+	for k, v := range map[string]string{"_id": "a1", "user": "joe"} {
+		if f := m.FindFirstField(k); f == nil || f.GetValue() != v {
+			t.Errorf("first: field %s is %v, wanted %q", k, f, v)
+		}
+	}
+	if m = (<-input).Message; m.GetPayload() != "second" || m.GetType() != "audit" {
+		t.Errorf("second: got %s", m)
+	}
+
+}
+
+func TestElasticDoc(t *testing.T) {
+	hsi, input := newTestInput(2)
+	hsi.elasticPath = "/es"
+
+	// the keys of the documents are not the message headers; a malformed
+	// action is reported as an item, after the ones already injected
+	body := `{"index": {}}
+{"message": "x", "severity": "info", "type": "nginx", "pid": "abc", "payload": "y"}
+not json
+{"index": {}}
+{"message": "skipped"}
+`
+	r, _ := http.NewRequest("POST", "/es/logs/_bulk", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 200 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	var res struct {
+		Errors bool                     `json:"errors"`
+		Items  []map[string]elasticItem `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("cannot parse response %q: %s", w.Body, err)
+	}
+	if !res.Errors || len(res.Items) != 2 || res.Items[0]["index"].Status != 201 ||
+		res.Items[1]["index"].Status != 400 || res.Items[1]["index"].Error == nil {
+		t.Fatalf("got %s", w.Body)
+	}
+
+	m := (<-input).Message
+	if m.GetPayload() != "x" || m.GetType() != "logs" || m.GetPid() != 0 || m.Severity != nil {
+		t.Errorf("got %s", m)
+	}
+	for _, k := range []string{"severity", "type", "pid", "payload"} {
+		if f := m.FindFirstField(k); f == nil {
+			t.Errorf("field %s is missing", k)
+		}
+	}
+	select {
+	case pack := <-input:
+		t.Errorf("injected after the malformed action: %s", pack.Message)
+	default:
+	}
+}
@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitFieldKey splits the "name:type:representation" query key.
+// Both type and representation are optional.
+func splitFieldKey(k string) (name, typ, repr string) {
+	parts := strings.SplitN(k, ":", 3)
+	name = parts[0]
+	if len(parts) > 1 {
+		typ = parts[1]
+	}
+	if len(parts) > 2 {
+		repr = parts[2]
+	}
+	return
+}
+
+// queryField returns the field for the query parameter's values.
+// The type is given by typ (int, double, bool, bytes or string),
+// or - if typ is empty and infer is true - guessed from the values.
+func queryField(name, typ, repr string, vs []string, infer bool) (*message.Field, error) {
+	if typ == "" {
+		if !infer {
+			typ = "string"
+		} else {
+			typ = inferType(vs)
+		}
+	}
+	typed := make([]interface{}, len(vs))
+	for i, v := range vs {
+		var err error
+		switch typ {
+		case "string", "str":
+			typed[i] = v
+		case "bytes":
+			typed[i] = []byte(v)
+		case "int", "integer":
+			typed[i], err = strconv.ParseInt(v, 10, 64)
+		case "double", "float":
+			typed[i], err = strconv.ParseFloat(v, 64)
+		case "bool":
+			typed[i], err = strconv.ParseBool(v)
+		default:
+			return nil, fmt.Errorf("unknown type %q for field %s", typ, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %q as %s for field %s: %s", v, typ, name, err)
+		}
+	}
+	return newField(name, repr, typed)
+}
+
+// inferType returns the narrowest type all the values can be parsed as
+func inferType(vs []string) string {
+	for _, typ := range []string{"int", "double", "bool"} {
+		ok := true
+		for _, v := range vs {
+			var err error
+			switch typ {
+			case "int":
+				_, err = strconv.ParseInt(v, 10, 64)
+			case "double":
+				// ParseFloat would accept "nan" and "inf", too
+				if _, err = strconv.ParseFloat(v, 64); err == nil && !strings.ContainsAny(v, "0123456789") {
+					err = strconv.ErrSyntax
+				}
+			case "bool":
+				if v != "true" && v != "false" {
+					err = strconv.ErrSyntax
+				}
+			}
+			if err != nil {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return typ
+		}
+	}
+	return "string"
+}
+
+// newField returns a field holding all the values, which must have
+// the same type
+func newField(name, repr string, vs []interface{}) (*message.Field, error) {
+	f, err := message.NewField(name, vs[0], repr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create field %s: %s", name, err)
+	}
+	for _, v := range vs[1:] {
+		if err = f.AddValue(v); err != nil {
+			return nil, fmt.Errorf("cannot add %v to field %s: %s", v, name, err)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,85 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := url.ParseQuery("severity=4&latency:int:ms=42&ok:bool=true&Size:INT:KiB=3" +
+		"&ratio:double=0.5&tag=a&tag=b&count=3&name=x&level=nan&codes=1&codes=2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := new(message.Message)
+	hsi := &HTTPSimpleInput{inferTypes: true}
+	if err = hsi.parseQuery(msg, q); err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetSeverity() != 4 {
+		t.Errorf("severity: got %d", msg.GetSeverity())
+	}
+	for k, want := range map[string]interface{}{
+		"latency": []int64{42},
+		"size":    []int64{3},
+		"ok":      []bool{true},
+		"ratio":   []float64{0.5},
+		"tag":     []string{"a", "b"},
+		"count":   []int64{3},
+		"name":    []string{"x"},
+		"level":   []string{"nan"},
+		"codes":   []float64{1, 2.5},
+	} {
+		f := msg.FindFirstField(k)
+		if f == nil {
+			t.Errorf("no field %s", k)
+			continue
+		}
+		var got interface{}
+		switch f.GetValueType() {
+		case message.Field_STRING:
+			got = f.GetValueString()
+		case message.Field_INTEGER:
+			got = f.GetValueInteger()
+		case message.Field_DOUBLE:
+			got = f.GetValueDouble()
+		case message.Field_BOOL:
+			got = f.GetValueBool()
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %#v, wanted %#v", k, got, want)
+		}
+	}
+	if f := msg.FindFirstField("latency"); f.GetRepresentation() != "ms" {
+		t.Errorf("latency representation: got %q", f.GetRepresentation())
+	}
+	if f := msg.FindFirstField("size"); f.GetRepresentation() != "KiB" {
+		t.Errorf("size representation: got %q", f.GetRepresentation())
+	}
+
+	msg = new(message.Message)
+	hsi.inferTypes = false
+	if err = hsi.parseQuery(msg, url.Values{"count": {"3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if f := msg.FindFirstField("count"); f.GetValueType() != message.Field_STRING {
+		t.Errorf("without inference, count should be a string, got %s", f)
+	}
+	if err = hsi.parseQuery(msg, url.Values{"n:int": {"x"}}); err == nil {
+		t.Errorf("n:int=x should fail")
+	}
+}
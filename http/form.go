@@ -0,0 +1,105 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxFormPartSize is the default limit of one multipart form part
+const DefaultMaxFormPartSize = 10 << 20
+
+// parseForm maps an application/x-www-form-urlencoded or multipart/form-data
+// body onto the message: the values as the query string's, the uploaded
+// files into the payload or byte fields (see FormFiles).
+func (hsi *HTTPSimpleInput) parseForm(msg *message.Message, body io.Reader,
+	mediaType string, params map[string]string) error {
+
+	if mediaType == "application/x-www-form-urlencoded" {
+		buf, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		q, err := url.ParseQuery(string(buf))
+		if err != nil {
+			return fmt.Errorf("error parsing form: %s", err)
+		}
+		return hsi.parseQuery(msg, q)
+	}
+
+	if params["boundary"] == "" {
+		return fmt.Errorf("no boundary for %s", mediaType)
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	q := make(url.Values)
+	var files []*message.Field
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading form: %s", err)
+		}
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+		buf, err := ioutil.ReadAll(&limitedReader{r: part, n: hsi.maxFormPartSize})
+		part.Close()
+		if err != nil {
+			return err
+		}
+		if part.FileName() == "" {
+			q.Add(name, string(buf))
+			continue
+		}
+		name = strings.ToLower(name)
+		if !hsi.formFileFields && msg.Payload == nil {
+			msg.SetPayload(string(buf))
+		} else {
+			f, err := message.NewField(name, buf, "")
+			if err != nil {
+				return fmt.Errorf("cannot create field %s: %s", name, err)
+			}
+			files = append(files, f)
+		}
+		for _, kv := range [][2]string{
+			{name + ".filename", part.FileName()},
+			{name + ".content_type", part.Header.Get("Content-Type")},
+		} {
+			if kv[1] == "" {
+				continue
+			}
+			f, err := message.NewField(kv[0], kv[1], "")
+			if err != nil {
+				return fmt.Errorf("cannot create field %s: %s", kv[0], err)
+			}
+			files = append(files, f)
+		}
+	}
+	if err := hsi.parseQuery(msg, q); err != nil {
+		return err
+	}
+	for _, f := range files {
+		msg.AddField(f)
+	}
+	return nil
+}
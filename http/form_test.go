@@ -0,0 +1,85 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestForm(t *testing.T) {
+	hsi, input := newTestInput(4)
+	hsi.maxFormPartSize = 16
+
+	post := func(ct, body string) int {
+		r, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", ct)
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w.Code
+	}
+
+	if code := post("application/x-www-form-urlencoded", "payload=hello&severity=3&n:int=42"); code != 201 {
+		t.Fatalf("urlencoded: got %d", code)
+	}
+	m := (<-input).Message
+	if m.GetPayload() != "hello" || m.GetSeverity() != 3 {
+		t.Errorf("urlencoded: got %s", m)
+	}
+	if f := m.FindFirstField("n"); f == nil || f.GetValueInteger()[0] != 42 {
+		t.Errorf("urlencoded: bad field n: %v", f)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("logger", "uploader")
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="log"; filename="app.log"`)
+	h.Set("Content-Type", "text/plain")
+	pw, _ := mw.CreatePart(h)
+	pw.Write([]byte("line1\nline2\n"))
+	fw, _ := mw.CreateFormFile("Extra", "x.bin")
+	fw.Write([]byte{0, 1, 2})
+	mw.Close()
+	if code := post(mw.FormDataContentType(), buf.String()); code != 201 {
+		t.Fatalf("multipart: got %d", code)
+	}
+	m = (<-input).Message
+	if m.GetPayload() != "line1\nline2\n" || m.GetLogger() != "uploader" {
+		t.Errorf("multipart: got %s", m)
+	}
+	for k, v := range map[string]string{
+		"log.filename": "app.log", "log.content_type": "text/plain",
+		"extra.filename": "x.bin",
+	} {
+		if f := m.FindFirstField(k); f == nil || f.GetValueString()[0] != v {
+			t.Errorf("multipart: field %s is %v, wanted %q", k, f, v)
+		}
+	}
+	if f := m.FindFirstField("extra"); f == nil || !bytes.Equal(f.GetValueBytes()[0], []byte{0, 1, 2}) {
+		t.Errorf("multipart: bad bytes field extra: %v", f)
+	}
+
+	buf.Reset()
+	mw = multipart.NewWriter(&buf)
+	fw, _ = mw.CreateFormFile("big", "big.txt")
+	fw.Write(bytes.Repeat([]byte{'x'}, 17))
+	mw.Close()
+	if code := post(mw.FormDataContentType(), buf.String()); code != 413 {
+		t.Errorf("too big part: got %d", code)
+	}
+}
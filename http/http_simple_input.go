@@ -15,255 +15,879 @@ import (
 	"code.google.com/p/go-uuid/uuid"
 	"github.com/mozilla-services/heka/message"
 	"github.com/mozilla-services/heka/pipeline"
+	"golang.org/x/net/netutil"
 
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultDrainTimeout is the default time to wait for the in-flight
+// requests on Stop
+const DefaultDrainTimeout = 10 * time.Second
+
+// errStopping is returned for the requests arriving while stopping
+var errStopping = errors.New("stopping")
+
 // HTTPSimpleInput holds the address where we listen to POST/PUT HTTP requests
 //Can be reached with `curl -XPOST 'http://localhost:5566/?payload=abbraka&severity=3'`
 type HTTPSimpleInput struct {
+	// stats is first for 64-bit alignment, as it is used atomically
+	stats stats
+	// listening is 1 while the listener is up
+	listening int32
+	// draining is 1 after Stop, while the in-flight requests finish
+	draining int32
+	// active counts the requests being handled: Shutdown does not wait
+	// for the ones on hijacked (h2c) connections
+	active int32
+
 	Address string
 
+	tlsConfig           *tls.Config
+	auths               []authenticator
+	batchDelim          []byte
+	flatJSON            bool
+	inferTypes          bool
+	timestampUnit       time.Duration // 0 means auto-detection
+	maxTimestampSkew    time.Duration
+	maxDecompressedSize int64
+	maxBodySize         int64
+	ipLimiter           *rateLimiter
+	tokenLimiter        *rateLimiter
+	packTimeout         time.Duration
+	healthPath          string
+	readyPath           string
+	statsPath           string
+	routes              []*route
+	socketConfig        SocketConfig
+	drainTimeout        time.Duration
+	deduper             *deduper
+	webhooks            []*webhook
+	decoderMappings     []decoderMapping
+	trustedProxies      []*net.IPNet
+	headers             []string
+	cors                *cors
+	beaconPath          string
+	serverSettings      serverSettings
+	recent              *recentMessages
+	recentPath          string
+	otlpPath            string
+	elasticPath         string
+	lokiPath            string
+	schemas             map[string]*schema
+	tagInvalid          bool
+	defaultDecoder      string
+	maxFormPartSize     int64
+	formFileFields      bool
+	ack                 bool
+
 	listener      net.Listener
+	server        *http.Server
 	packs         chan *pipeline.PipelinePack
 	input         chan *pipeline.PipelinePack
+	acks          chan injection
 	DecoderRunner func(name string) (dRunner pipeline.DecoderRunner, ok bool)
 	stop          chan bool
+	done          chan struct{}
 	errch         chan error
 }
 
 // Stop is called when the main hekad wants to stop
 func (hsi *HTTPSimpleInput) Stop() {
 	if hsi.stop != nil {
-		hsi.stop <- true
+		select {
+		case hsi.stop <- true:
+		case <-hsi.done:
+		}
 	}
 }
 
 func (hsi *HTTPSimpleInput) listen() {
+	defer close(hsi.errch)
 	var err error
-	if hsi.listener, err = net.Listen("tcp", hsi.Address); err != nil {
+	if hsi.listener, err = listen(hsi.Address, hsi.socketConfig); err != nil {
 		hsi.errch <- err
 		return
 	}
-	s := &http.Server{Addr: hsi.Address, Handler: http.HandlerFunc(hsi.handler)}
-	if err = s.Serve(hsi.listener); err != nil && hsi != nil && hsi.errch != nil {
-		hsi.errch <- err
+	if hsi.serverSettings.MaxConns > 0 {
+		hsi.listener = netutil.LimitListener(hsi.listener, hsi.serverSettings.MaxConns)
 	}
-	if hsi.errch != nil {
-		close(hsi.errch)
+	atomic.StoreInt32(&hsi.listening, 1)
+	defer atomic.StoreInt32(&hsi.listening, 0)
+	if hsi.tlsConfig != nil {
+		// the certificates are already loaded into tlsConfig
+		err = hsi.server.ServeTLS(hsi.listener, "", "")
+	} else {
+		err = hsi.server.Serve(hsi.listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		hsi.errch <- err
 	}
 }
 
 // Run is the main loop which listens for incoming requests and injects the
-// messages read into the heka machinery
+// messages read into the heka machinery.
+// On Stop, it stops accepting, and waits at most drainTimeout for the
+// in-flight requests to finish, injecting their messages.
 func (hsi *HTTPSimpleInput) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) (err error) {
+	hsi.server = hsi.newServer(ir.LogError)
+	if ds := hsi.uncheckedDecoders(); len(hsi.schemas) > 0 && len(ds) > 0 {
+		ir.LogMessage(fmt.Sprintf("the messages of the decoders %s are not checked against the schemas",
+			strings.Join(ds, ", ")))
+	}
 	hsi.stop = make(chan bool)
 	hsi.input = make(chan *pipeline.PipelinePack)
+	hsi.acks = make(chan injection)
+	hsi.done = make(chan struct{})
 	hsi.errch = make(chan error, 1)
 	hsi.packs = ir.InChan()
 	hsi.DecoderRunner = h.DecoderRunner
+	atomic.StoreInt32(&hsi.draining, 0)
+	// releases the handlers still waiting to hand over their packs
+	defer close(hsi.done)
 
 	go hsi.listen()
-	var pack *pipeline.PipelinePack
-INPUT:
+	errch := hsi.errch
+	var drained chan error
 	for {
 		select {
-		case err = <-hsi.errch:
-			if err != nil {
-				return
+		case e, ok := <-errch:
+			if !ok {
+				// Serve returned because of Shutdown
+				errch = nil
+				continue
 			}
-		case pack = <-hsi.input:
+			if e != nil {
+				return e
+			}
+		case pack := <-hsi.input:
 			ir.Inject(pack)
+		case in := <-hsi.acks:
+			in.res <- ir.Inject(in.pack)
 		case _ = <-hsi.stop:
-			if hsi.listener != nil {
-				hsi.listener.Close()
-				hsi.packs = nil
+			atomic.StoreInt32(&hsi.draining, 1)
+			drained = make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), hsi.drainTimeout)
+				defer cancel()
+				err := hsi.server.Shutdown(ctx)
+				if err == nil {
+					err = hsi.waitActive(ctx)
+				}
+				drained <- err
+			}()
+		case err = <-drained:
+			if err != nil {
+				ir.LogError(fmt.Errorf("not all requests finished in %s: %s", hsi.drainTimeout, err))
+				hsi.server.Close()
 			}
-			break INPUT
+			return nil
 		}
 	}
-	select {
-	case err = <-hsi.errch:
-		return
-	default:
-		close(hsi.errch)
-		hsi.errch = nil
+}
+
+// waitActive waits till no request is being handled, or ctx is done
+func (hsi *HTTPSimpleInput) waitActive(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&hsi.active) != 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
 	}
 	return nil
 }
 
 func (hsi *HTTPSimpleInput) handler(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&hsi.active, 1)
+	defer atomic.AddInt32(&hsi.active, -1)
 	if r.Body != nil {
 		defer r.Body.Close()
 	}
+	if hsi.serveStatus(w, r) || hsi.cors.handle(w, r) {
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	parsErr := func(err error) {
-		w.WriteHeader(400)
+	httpErr := func(code int, err error) {
+		atomic.AddInt64(&hsi.stats.Rejected, 1)
+		w.WriteHeader(code)
 		w.Write([]byte(err.Error()))
 		w.Write([]byte{'\n'})
 	}
-	if r.Method != "POST" && r.Method != "PUT" {
+	stopping := func() {
+		setRetryAfter(w, hsi.drainTimeout)
+		httpErr(http.StatusServiceUnavailable, errStopping)
+	}
+	injectErr := func(err error) {
+		if err == errStopping {
+			stopping()
+			return
+		}
+		httpErr(http.StatusServiceUnavailable, err)
+	}
+	if atomic.LoadInt32(&hsi.draining) == 1 {
+		w.Header().Set("Connection", "close")
+		stopping()
+		return
+	}
+	parsErr := func(err error) {
+		httpErr(400, err)
+	}
+	decodeErr := func(err error) {
+		atomic.AddInt64(&hsi.stats.DecodeFailed, 1)
+		parsErr(err)
+	}
+	// browsers' beacons are not authenticated, and may be image GETs
+	isBeacon := hsi.beaconPath != "" && r.URL.Path == hsi.beaconPath
+	isRecent := hsi.recent != nil && r.URL.Path == hsi.recentPath && r.Method == "GET"
+	elastic, index := hsi.elasticRequest(r)
+	if r.Method != "POST" && r.Method != "PUT" && !(isBeacon && r.Method == "GET") && !isRecent &&
+		elastic != "info" {
 		parsErr(fmt.Errorf("POST needed!"))
 		return
 	}
+	if hsi.maxBodySize > 0 && r.Body != nil {
+		if r.ContentLength > hsi.maxBodySize {
+			httpErr(http.StatusRequestEntityTooLarge,
+				fmt.Errorf("body is bigger than %d bytes", hsi.maxBodySize))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, hsi.maxBodySize)
+	}
+	if r.Body != nil {
+		r.Body = countingReader{ReadCloser: r.Body, n: &hsi.stats.BytesIn}
+	}
+	limited := func(d time.Duration) {
+		atomic.AddInt64(&hsi.stats.RateLimited, 1)
+		setRetryAfter(w, d)
+		httpErr(http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded"))
+	}
+	ip := hsi.clientIP(r)
+	if ok, d := hsi.ipLimiter.allow(ip); !ok {
+		limited(d)
+		return
+	}
+	// the webhooks are authenticated by their own signature
+	wh := hsi.matchWebhook(r.URL.Path)
+	var id *identity
 	var err error
-	pack := <-hsi.packs
+	if wh != nil {
+		id, err = wh.authenticate(r)
+	} else if !isBeacon || isRecent {
+		id, err = hsi.authenticate(r)
+	}
+	if err != nil {
+		if ae, ok := err.(authError); ok {
+			atomic.AddInt64(&hsi.stats.AuthRejected, 1)
+			for _, c := range ae.Challenges {
+				w.Header().Add("WWW-Authenticate", c)
+			}
+			httpErr(ae.Code, err)
+			return
+		}
+		httpErr(bodyErrCode(err), err)
+		return
+	}
+	req := request{Request: r, id: id, route: hsi.matchRoute(r.URL.Path),
+		webhook: wh, clientIP: ip}
+	if !req.route.allows(id) {
+		atomic.AddInt64(&hsi.stats.AuthRejected, 1)
+		httpErr(http.StatusForbidden, fmt.Errorf("not allowed on %s", r.URL.Path))
+		return
+	}
+	if id != nil {
+		if ok, d := hsi.tokenLimiter.allow(id.Name); !ok {
+			limited(d)
+			return
+		}
+	}
+	if isRecent {
+		hsi.serveRecent(w, req)
+		return
+	}
+	if elastic == "info" {
+		serveElasticInfo(w, r)
+		return
+	}
+	// a replay of an accepted request gets the original response
+	var finishDedup func()
 	defer func() {
-		if err != nil && pack != nil {
-			pack.Recycle()
+		if finishDedup != nil {
+			finishDedup()
 		}
 	}()
-
-	ct := r.Header.Get("Content-Type")
-	if ct != "" && strings.HasPrefix(ct, "application/") &&
-		(ct == "application/json" || ct == "application/x-protobuf") {
-		k := "JSON"
-		if ct == "application/x-protobuf" {
-			k = "PROTOCOL_BUFFER"
-		}
-		dr, ok := hsi.DecoderRunner(k)
-		if !ok {
-			parsErr(fmt.Errorf("cannot get decoder for %s", k))
-			return
+	replayed := func(key string) bool {
+		if hsi.deduper == nil || key == "" || finishDedup != nil {
+			return false
+		}
+		var idName string
+		if id != nil {
+			idName = id.Name
 		}
-		if pack.MsgBytes, err = ioutil.ReadAll(r.Body); err != nil {
-			parsErr(fmt.Errorf("error reading request body: %s", err))
+		var ok bool
+		finishDedup, ok = hsi.dedup(&w, idName, key)
+		return ok
+	}
+	if replayed(r.Header.Get("Idempotency-Key")) {
+		return
+	}
+	noPack := func(err error) {
+		if err == errStopping {
+			stopping()
 			return
 		}
-		w.WriteHeader(201)
-		dr.InChan() <- pack
-		w.Write([]byte{})
+		atomic.AddInt64(&hsi.stats.PackTimeouts, 1)
+		setRetryAfter(w, time.Second)
+		httpErr(http.StatusServiceUnavailable, errNoPack)
+	}
+	if r.Body, err = decompressBody(r, hsi.maxDecompressedSize); err != nil {
+		httpErr(bodyErrCode(err), err)
 		return
 	}
-	q := r.URL.Query()
-	var (
-		i int64
-		s string
-		f *message.Field
-	)
-	start := time.Now().UnixNano() - 1000000
-
-	for k, vs := range q {
-		k = strings.ToLower(k)
-		switch k {
-		case "uuid":
-			pack.Message.Uuid = []byte(vs[0])
-		case "timestamp":
-			s = vs[0]
-			i := strings.Index(s, ".")
-			if i > 0 {
-				s = s[:i]
-			}
-			ts, e := strconv.ParseInt(s, 10, 64)
-			if e != nil {
-				parsErr(fmt.Errorf("error parsing timestamp %s: %s", s, e))
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	// flat JSON objects are mapped by us, Heka's JSON goes to the decoder
+	isJSON := hsi.flatJSON && mediaType == "application/json"
+	var k string
+	isOTLP := hsi.otlpPath != "" && r.URL.Path == hsi.otlpPath
+	isLoki := hsi.lokiPath != "" && r.URL.Path == hsi.lokiPath
+	if wh == nil && !isBeacon && !isOTLP && !isLoki && elastic == "" {
+		k = hsi.decoderFor(mediaType, r.URL.Path)
+	}
+	// sendBeacon posts text/plain, to avoid the preflight
+	isJSON = isJSON || isBeacon && mediaType == "text/plain"
+	if k != "" {
+		var dr pipeline.DecoderRunner
+		var msgUUID string
+		if !hsi.ack || k != "JSON" && k != "PROTOCOL_BUFFER" {
+			// in ack mode, the message is decoded here, to report the errors
+			var ok bool
+			if dr, ok = hsi.DecoderRunner(k); !ok {
+				parsErr(fmt.Errorf("cannot get decoder for %s", k))
 				return
 			}
-			pack.Message.Timestamp = &ts
-		case "type":
-			if vs[0] != "" {
-				t := vs[0]
-				pack.Message.Type = &t
-			}
-		case "logger":
-			if vs[0] != "" {
-				t := vs[0]
-				pack.Message.Logger = &t
-			}
-		case "severity":
-			if i, err = strconv.ParseInt(vs[0], 10, 32); err != nil {
-				parsErr(fmt.Errorf("error parsing severity %s: %s", vs[0], err))
+		}
+		// a slow upload must not hold a pack
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			httpErr(bodyErrCode(err), fmt.Errorf("error reading request body: %s", err))
+			return
+		}
+		pack, err := hsi.getPack()
+		if err != nil {
+			noPack(err)
+			return
+		}
+		pack.MsgBytes = body
+		if dr == nil {
+			if err = decodePack(pack, k); err != nil {
+				pack.Recycle()
+				decodeErr(err)
 				return
 			}
-			j := int32(i)
-			pack.Message.Severity = &j
-		case "envversion":
-			if vs[0] != "" {
-				t := vs[0]
-				pack.Message.EnvVersion = &t
-
-			}
-		case "hostname":
-			if vs[0] != "" {
-				t := vs[0]
-				pack.Message.Hostname = &t
+			if replayed(string(pack.Message.Uuid)) {
+				pack.Recycle()
+				return
 			}
-		case "pid":
-			if vs[0] != "" {
-				if i, err = strconv.ParseInt(vs[0], 10, 32); err != nil {
-					parsErr(fmt.Errorf("error parsing pid %s: %s", vs[0], err))
-					return
-				}
-				j := int32(i)
-				pack.Message.Pid = &j
-
+			if len(pack.Message.Uuid) == 0 {
+				pack.Message.Uuid = []byte(uuid.NewRandom())
 			}
-		case "payload":
-			s = strings.Join(vs, " ")
-			if s != "" {
-				t := s
-				pack.Message.Payload = &t
+			req.id.apply(pack.Message)
+			hsi.addMetadata(pack.Message, req)
+			addClientSubject(pack.Message, r)
+			if err = hsi.checkMessage(pack.Message); err != nil {
+				pack.Recycle()
+				hsi.writeInvalid(w, err.(schemaError))
+				return
 			}
-		default:
-			if f, err = message.NewField(k, vs[0], vs[0]); err != nil {
-				parsErr(fmt.Errorf("cannot create field for %q=%q: %s", k, vs[0], err))
+			if msgUUID, err = hsi.inject(pack, req.route); err != nil {
+				injectErr(err)
+				return
 			}
-			if f != nil && f.ValueType != nil {
-				pack.Message.AddField(f)
+		} else {
+			select {
+			case dr.InChan() <- pack:
+			case <-hsi.done:
+				pack.Recycle()
+				stopping()
+				return
 			}
 		}
+		atomic.AddInt64(&hsi.stats.Accepted, 1)
+		if dr != nil && hsi.ack {
+			// the decoder owns the pack: there is no uuid nor injection to tell
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		hsi.writeCreated(w, msgUUID)
+		return
+	}
+	msg := new(message.Message)
+	if err = hsi.parseQuery(msg, r.URL.Query()); err != nil {
+		decodeErr(err)
+		return
+	}
+	isForm := mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+	if isOTLP {
+		hsi.handleOTLP(w, req, msg, mediaType == "application/json")
+		return
+	}
+	if isLoki {
+		hsi.handleLoki(w, req, msg, mediaType == "application/json")
+		return
 	}
-	if pack.Message.Payload == nil || *pack.Message.Payload == "" {
+	if elastic == "bulk" {
+		hsi.handleElasticBulk(w, req, msg, index)
+		return
+	}
+	if octets, ok := syslogMediaTypes[mediaType]; ok && wh == nil {
+		// every syslog line or frame is a message
+		split, parse := splitSyslogLines, parseSyslog
+		if octets {
+			split = splitOctetCounted
+		}
+		if mediaType == "application/logplex-1" {
+			parse = parseLogplex
+		}
+		hsi.handleBatch(w, req, msg, split, parse)
+		return
+	}
+	if hsi.batchDelim != nil && !isForm && wh == nil && !isBeacon {
+		hsi.handleBatch(w, req, msg, hsi.splitLines, func(msg *message.Message, line []byte) error {
+			return hsi.parseLine(msg, line, isJSON)
+		})
+		return
+	}
+	if wh != nil {
 		var buf []byte
 		if buf, err = ioutil.ReadAll(r.Body); err != nil {
-			parsErr(fmt.Errorf("error reading body: %s", err))
+			httpErr(bodyErrCode(err), fmt.Errorf("error reading body: %s", err))
+			return
+		}
+		if err = wh.parse(msg, r, buf); err != nil {
+			decodeErr(err)
+			return
+		}
+	} else if isForm {
+		if err = hsi.parseForm(msg, r.Body, mediaType, params); err != nil {
+			if code := bodyErrCode(err); code != 400 {
+				httpErr(code, err)
+			} else {
+				decodeErr(err)
+			}
 			return
 		}
-		t := string(buf)
-		pack.Message.Payload = &t
+	} else if isJSON {
+		var buf []byte
+		if buf, err = ioutil.ReadAll(r.Body); err != nil {
+			httpErr(bodyErrCode(err), fmt.Errorf("error reading body: %s", err))
+			return
+		}
+		if err = hsi.parseJSON(msg, buf); err != nil {
+			decodeErr(err)
+			return
+		}
+	} else if msg.Payload == nil || *msg.Payload == "" {
+		var buf []byte
+		if buf, err = ioutil.ReadAll(r.Body); err != nil {
+			httpErr(bodyErrCode(err), fmt.Errorf("error reading body: %s", err))
+			return
+		}
+		msg.SetPayload(string(buf))
+	}
+	if replayed(string(msg.Uuid)) {
+		return
 	}
-	if pack.Message.Hostname == nil {
-		pack.Message.SetHostname(r.Host)
+	hsi.finishMessage(msg, req)
+	if err = hsi.checkMessage(msg); err != nil {
+		hsi.writeInvalid(w, err.(schemaError))
+		return
 	}
-	if pack.Message.Uuid == nil || len(pack.Message.Uuid) == 0 {
-		pack.Message.Uuid = []byte(uuid.NewRandom())
+
+	pack, err := hsi.getPack()
+	if err != nil {
+		noPack(err)
+		return
 	}
-	if pack.Message.Type == nil {
-		pack.Message.SetType("heka.httpdata-simple")
+	pack.Message = msg
+	pack.Decoded = true
+	msgUUID, err := hsi.inject(pack, req.route)
+	if err != nil {
+		injectErr(err)
+		return
 	}
-	if pack.Message.Timestamp == nil || *pack.Message.Timestamp < start {
-		//fmt.Printf("setting timestamp to %s", time.Now().UnixNano())
-		pack.Message.SetTimestamp(time.Now().UnixNano())
+	atomic.AddInt64(&hsi.stats.Accepted, 1)
+	if isBeacon && r.Method == "GET" {
+		writePixel(w)
+		return
 	}
-	w.WriteHeader(201)
+	hsi.writeCreated(w, msgUUID)
+}
 
-	pack.Decoded = true
-	w.Write([]byte{})
-	hsi.input <- pack
+// parseQuery sets the message's values from the query string:
+// the well-known keys set the message's headers, the others go into fields.
+// Field keys may have type and representation suffixes (name:type:repr);
+// without type, the values are strings - or guessed if inferTypes is set.
+// Repeated keys give multi-value fields.
+func (hsi *HTTPSimpleInput) parseQuery(msg *message.Message, q url.Values) error {
+	for k, vs := range q {
+		// the representation (a unit) is kept as sent
+		name, typ, repr := splitFieldKey(k)
+		name, typ = strings.ToLower(name), strings.ToLower(typ)
+		ok, err := hsi.setHeader(msg, name, vs)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		f, err := queryField(name, typ, repr, vs, hsi.inferTypes)
+		if err != nil {
+			return err
+		}
+		msg.AddField(f)
+	}
+	return nil
+}
+
+// setHeader sets the message's header named by the (lowercase) key k,
+// and returns whether k is a well-known key.
+func (hsi *HTTPSimpleInput) setHeader(msg *message.Message, k string, vs []string) (bool, error) {
+	var (
+		i   int64
+		s   string
+		err error
+	)
+	switch k {
+	case "uuid":
+		msg.Uuid = []byte(vs[0])
+	case "timestamp":
+		ts, e := parseTimestamp(vs[0], hsi.timestampUnit)
+		if e != nil {
+			return true, e
+		}
+		msg.Timestamp = &ts
+	case "type":
+		if vs[0] != "" {
+			t := vs[0]
+			msg.Type = &t
+		}
+	case "logger":
+		if vs[0] != "" {
+			t := vs[0]
+			msg.Logger = &t
+		}
+	case "severity":
+		if i, err = strconv.ParseInt(vs[0], 10, 32); err != nil {
+			return true, fmt.Errorf("error parsing severity %s: %s", vs[0], err)
+		}
+		j := int32(i)
+		msg.Severity = &j
+	case "envversion":
+		if vs[0] != "" {
+			t := vs[0]
+			msg.EnvVersion = &t
+
+		}
+	case "hostname":
+		if vs[0] != "" {
+			t := vs[0]
+			msg.Hostname = &t
+		}
+	case "pid":
+		if vs[0] != "" {
+			if i, err = strconv.ParseInt(vs[0], 10, 32); err != nil {
+				return true, fmt.Errorf("error parsing pid %s: %s", vs[0], err)
+			}
+			j := int32(i)
+			msg.Pid = &j
+
+		}
+	case "payload":
+		s = strings.Join(vs, " ")
+		if s != "" {
+			t := s
+			msg.Payload = &t
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// finishMessage applies the identity's overrides, records the request's
+// data and fills the missing headers with the route's and our defaults.
+func (hsi *HTTPSimpleInput) finishMessage(msg *message.Message, req request) {
+	r := req.Request
+	req.id.apply(msg)
+	hsi.addMetadata(msg, req)
+	addClientSubject(msg, r)
+	req.route.applyDefaults(msg)
+	if msg.Uuid == nil || len(msg.Uuid) == 0 {
+		msg.Uuid = []byte(uuid.NewRandom())
+	}
+	if msg.Type == nil {
+		msg.SetType("heka.httpdata-simple")
+	}
+	if msg.Timestamp == nil {
+		msg.SetTimestamp(time.Now().UnixNano())
+	}
 }
 
 // HTTPSimpleInputConfig holds the user-configurable values:
-//the HTTP address we should listen on
+//the HTTP address we should listen on, the TLS and authentication settings
 type HTTPSimpleInputConfig struct {
-	Address string `toml:"address"`
+	// Address is host:port for TCP, "unix:/path" for a Unix domain socket,
+	// or "systemd" (or "systemd:name") for a socket activated by systemd
+	Address string       `toml:"address"`
+	Socket  SocketConfig `toml:"socket"`
+	UseTLS  bool         `toml:"use_tls"`
+	TLS     TLSConfig    `toml:"tls"`
+	Auth    AuthConfig   `toml:"auth"`
+	// Batch makes every line of the body (split by BatchDelimiter,
+	// "\n" by default) a separate message
+	Batch          bool   `toml:"batch"`
+	BatchDelimiter string `toml:"batch_delimiter"`
+	// JSONMode selects how application/json bodies are handled:
+	// "heka" passes them to the JSON decoder (Heka's message form),
+	// "flat" maps arbitrary JSON objects onto the message
+	JSONMode string `toml:"json_mode"`
+	// InferTypes makes the query string's untyped fields integer, double
+	// or bool if all their values can be parsed as such
+	InferTypes bool `toml:"infer_types"`
+	// TimestampUnit is the unit of numeric timestamps (s, ms, us, ns),
+	// auto-detected from the magnitude if empty or "auto"
+	TimestampUnit string `toml:"timestamp_unit"`
+	// MaxTimestampSkew rejects the messages whose timestamp is farther
+	// from the current time than this (e.g. "720h")
+	MaxTimestampSkew string `toml:"max_timestamp_skew"`
+	// MaxDecompressedSize limits the size of the gzip, deflate or zstd
+	// compressed bodies after decompression (32MiB by default)
+	MaxDecompressedSize int64 `toml:"max_decompressed_size"`
+	// MaxBodySize limits the size of the (raw) request body
+	MaxBodySize int64           `toml:"max_body_size"`
+	RateLimit   RateLimitConfig `toml:"rate_limit"`
+	// OTLPPath receives the OpenTelemetry OTLP/HTTP logs (as "/v1/logs"),
+	// protobuf or JSON encoded
+	OTLPPath string `toml:"otlp_path"`
+	// ElasticsearchPath is the prefix of an emulated Elasticsearch _bulk
+	// API (as "/es", for "/es/_bulk" and "/es/{index}/_bulk")
+	ElasticsearchPath string `toml:"elasticsearch_path"`
+	// LokiPath receives the Loki push requests (as "/loki/api/v1/push")
+	LokiPath string `toml:"loki_path"`
+	// Schemas constrain the messages per type
+	Schemas []SchemaConfig `toml:"schemas"`
+	// SchemaMode is "reject" (422, the default) or "tag" (the violations
+	// are listed in the "invalid" field) for the invalid messages
+	SchemaMode string `toml:"schema_mode"`
+	// Recent keeps the last messages injected, per route, to be queried
+	Recent RecentConfig `toml:"recent"`
+	// Server holds the timeouts and the connection limits
+	Server ServerConfig `toml:"server"`
+	// PackTimeout is the maximal time to wait for a free pack
+	// before returning 503 (5s by default)
+	PackTimeout string `toml:"pack_timeout"`
+	// Ack makes the response wait until the message is injected, and
+	// return its uuid; Heka JSON and protobuf bodies are decoded by the input
+	Ack bool `toml:"ack"`
+	// FormFiles selects where the files uploaded in a multipart form go:
+	// "payload" (the first file, by default) or "fields" (byte fields)
+	FormFiles string `toml:"form_files"`
+	// MaxFormPartSize is the size limit of one multipart form part
+	// (10MiB by default)
+	MaxFormPartSize int64 `toml:"max_form_part_size"`
+	// Decoders maps content types (and paths) to the decoders;
+	// application/json goes to JSON (unless flat), application/x-protobuf
+	// to PROTOCOL_BUFFER, if not mapped otherwise
+	Decoders []DecoderMapping `toml:"decoders"`
+	// DefaultDecoder gets the content types not handled by the input itself
+	// (text/plain, forms, flat JSON) nor mapped
+	DefaultDecoder string `toml:"default_decoder"`
+	// TrustedProxies are the addresses (or CIDR ranges) of the proxies whose
+	// Forwarded or X-Forwarded-For headers tell the client's address
+	TrustedProxies []string `toml:"trusted_proxies"`
+	// Headers are the request headers added as "Header.Name" fields
+	Headers []string `toml:"headers"`
+	// CORS allows posting from the browsers of the given origins
+	CORS CORSConfig `toml:"cors"`
+	// BeaconPath accepts the unauthenticated browser beacons: text/plain
+	// JSON objects (navigator.sendBeacon), and GETs with the query string,
+	// answered with a 1x1 GIF
+	BeaconPath string `toml:"beacon_path"`
+	// Webhooks are the endpoints of the webhook providers
+	Webhooks []WebhookConfig `toml:"webhooks"`
+	// Dedup remembers the accepted Idempotency-Key headers and message uuids
+	// for a while, and answers their replays with the original response
+	Dedup DedupConfig `toml:"dedup"`
+	// DrainTimeout is the maximal time to wait for the in-flight requests
+	// on stop (10s by default)
+	DrainTimeout string `toml:"drain_timeout"`
+	// HealthPath, ReadyPath and StatsPath are the paths answering GET
+	// requests with the health, the readiness and the counters (as JSON).
+	// Empty path disables the endpoint.
+	HealthPath string `toml:"health_path"`
+	ReadyPath  string `toml:"ready_path"`
+	StatsPath  string `toml:"stats_path"`
+	// Routes give per-path message defaults and allowed tokens
+	Routes []RouteConfig `toml:"routes"`
 }
 
 // ConfigStruct returns a new config struct to be used to read the config file
 func (hsi *HTTPSimpleInput) ConfigStruct() interface{} {
-	return new(HTTPSimpleInputConfig)
+	return &HTTPSimpleInputConfig{
+		HealthPath: "/healthz",
+		ReadyPath:  "/readyz",
+		StatsPath:  "/stats",
+	}
 }
 
 // Init initializes the Input instance by extracting the address value
 //from the config and store it on the plugin instance.
-func (hsi *HTTPSimpleInput) Init(config interface{}) error {
+func (hsi *HTTPSimpleInput) Init(config interface{}) (err error) {
 	conf := config.(*HTTPSimpleInputConfig)
-	hsi.Address = conf.Address
+	hsi.Address, hsi.socketConfig = conf.Address, conf.Socket
+	hsi.inferTypes = conf.InferTypes
+	if hsi.maxDecompressedSize = conf.MaxDecompressedSize; hsi.maxDecompressedSize <= 0 {
+		hsi.maxDecompressedSize = DefaultMaxDecompressedSize
+	}
+	hsi.maxBodySize = conf.MaxBodySize
+	hsi.healthPath, hsi.readyPath, hsi.statsPath = conf.HealthPath, conf.ReadyPath, conf.StatsPath
+	hsi.ack = conf.Ack
+	switch conf.FormFiles {
+	case "", "payload":
+	case "fields":
+		hsi.formFileFields = true
+	default:
+		return fmt.Errorf("form_files must be payload or fields, not %q", conf.FormFiles)
+	}
+	if hsi.maxFormPartSize = conf.MaxFormPartSize; hsi.maxFormPartSize <= 0 {
+		hsi.maxFormPartSize = DefaultMaxFormPartSize
+	}
+	if conf.Dedup.Window != "" {
+		var window time.Duration
+		if window, err = time.ParseDuration(conf.Dedup.Window); err != nil {
+			return fmt.Errorf("error parsing dedup window %q: %s", conf.Dedup.Window, err)
+		}
+		hsi.deduper = newDeduper(window, conf.Dedup.Size)
+	}
+	hsi.drainTimeout = DefaultDrainTimeout
+	if conf.DrainTimeout != "" {
+		if hsi.drainTimeout, err = time.ParseDuration(conf.DrainTimeout); err != nil {
+			return fmt.Errorf("error parsing drain_timeout %q: %s", conf.DrainTimeout, err)
+		}
+	}
+	if hsi.routes, err = newRoutes(conf.Routes); err != nil {
+		return err
+	}
+	if hsi.webhooks, err = newWebhooks(conf.Webhooks); err != nil {
+		return err
+	}
+	if hsi.decoderMappings, err = newDecoderMappings(conf.Decoders); err != nil {
+		return err
+	}
+	hsi.defaultDecoder = conf.DefaultDecoder
+	if hsi.trustedProxies, err = parseTrustedProxies(conf.TrustedProxies); err != nil {
+		return err
+	}
+	hsi.cors = newCORS(conf.CORS)
+	if conf.BeaconPath != "" && conf.BeaconPath[0] != '/' {
+		return fmt.Errorf("beacon_path %q must start with /", conf.BeaconPath)
+	}
+	hsi.beaconPath = conf.BeaconPath
+	hsi.headers = make([]string, len(conf.Headers))
+	for i, h := range conf.Headers {
+		hsi.headers[i] = http.CanonicalHeaderKey(h)
+	}
+	hsi.ipLimiter = newRateLimiter(conf.RateLimit.PerIP, conf.RateLimit.PerIPBurst)
+	hsi.tokenLimiter = newRateLimiter(conf.RateLimit.PerToken, conf.RateLimit.PerTokenBurst)
+	if hsi.serverSettings, err = newServerSettings(conf.Server); err != nil {
+		return err
+	}
+	if conf.OTLPPath != "" && conf.OTLPPath[0] != '/' {
+		return fmt.Errorf("otlp_path %q must start with /", conf.OTLPPath)
+	}
+	hsi.otlpPath = conf.OTLPPath
+	for _, p := range []string{conf.ElasticsearchPath, conf.LokiPath} {
+		if p != "" && p[0] != '/' {
+			return fmt.Errorf("path %q must start with /", p)
+		}
+	}
+	hsi.elasticPath = conf.ElasticsearchPath
+	hsi.lokiPath = conf.LokiPath
+	if hsi.schemas, err = newSchemas(conf.Schemas); err != nil {
+		return err
+	}
+	switch conf.SchemaMode {
+	case "", "reject":
+	case "tag":
+		hsi.tagInvalid = true
+	default:
+		return fmt.Errorf("unknown schema_mode %q", conf.SchemaMode)
+	}
+	hsi.recent = newRecentMessages(conf.Recent.Size)
+	if hsi.recentPath = conf.Recent.Path; hsi.recentPath == "" {
+		hsi.recentPath = DefaultRecentPath
+	}
+	hsi.packTimeout = DefaultPackTimeout
+	if conf.PackTimeout != "" {
+		if hsi.packTimeout, err = time.ParseDuration(conf.PackTimeout); err != nil {
+			return fmt.Errorf("error parsing pack_timeout %q: %s", conf.PackTimeout, err)
+		}
+	}
+	switch conf.TimestampUnit {
+	case "", "auto":
+	case "s":
+		hsi.timestampUnit = time.Second
+	case "ms":
+		hsi.timestampUnit = time.Millisecond
+	case "us":
+		hsi.timestampUnit = time.Microsecond
+	case "ns":
+		hsi.timestampUnit = time.Nanosecond
+	default:
+		return fmt.Errorf("unknown timestamp_unit %q", conf.TimestampUnit)
+	}
+	if conf.MaxTimestampSkew != "" {
+		if hsi.maxTimestampSkew, err = time.ParseDuration(conf.MaxTimestampSkew); err != nil {
+			return fmt.Errorf("error parsing max_timestamp_skew %q: %s", conf.MaxTimestampSkew, err)
+		}
+	}
+	if conf.UseTLS {
+		if hsi.tlsConfig, err = conf.TLS.newTLSConfig(); err != nil {
+			return err
+		}
+	}
+	if hsi.auths, err = conf.Auth.newAuthenticators(); err != nil {
+		return err
+	}
+	switch conf.JSONMode {
+	case "", "heka":
+	case "flat":
+		hsi.flatJSON = true
+	default:
+		return fmt.Errorf("unknown json_mode %q", conf.JSONMode)
+	}
+	if conf.Batch {
+		hsi.batchDelim = []byte{'\n'}
+		if conf.BatchDelimiter != "" {
+			hsi.batchDelim = []byte(conf.BatchDelimiter)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,145 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseJSON maps the JSON object in buf onto the message.
+// The well-known keys are handled as in the query string, the rest
+// go into typed fields: nested objects are flattened with dotted names,
+// arrays become multi-value fields.
+// Without a "payload" key, the JSON itself is the payload.
+func (hsi *HTTPSimpleInput) parseJSON(msg *message.Message, buf []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return fmt.Errorf("error parsing JSON object: %s", err)
+	}
+	values := make(map[string][]interface{}, len(obj))
+	flattenJSON(values, "", obj)
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		vs := values[k]
+		lk := strings.ToLower(k)
+		if strings.IndexByte(lk, '.') < 0 {
+			ok, err := hsi.setHeader(msg, lk, jsonStrings(vs))
+			if err != nil {
+				return err
+			}
+			if ok {
+				continue
+			}
+		}
+		f, err := jsonField(k, vs)
+		if err != nil {
+			return err
+		}
+		msg.AddField(f)
+	}
+	if msg.Payload == nil || *msg.Payload == "" {
+		msg.SetPayload(string(buf))
+	}
+	return nil
+}
+
+// flattenJSON collects the values of v under dotted names into values
+func flattenJSON(values map[string][]interface{}, name string, v interface{}) {
+	switch x := v.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		for k, sub := range x {
+			if name != "" {
+				k = name + "." + k
+			}
+			flattenJSON(values, k, sub)
+		}
+	case []interface{}:
+		for _, sub := range x {
+			flattenJSON(values, name, sub)
+		}
+	default:
+		values[name] = append(values[name], x)
+	}
+}
+
+// jsonStrings returns the string forms of the values
+func jsonStrings(vs []interface{}) []string {
+	ss := make([]string, len(vs))
+	for i, v := range vs {
+		switch x := v.(type) {
+		case string:
+			ss[i] = x
+		default:
+			ss[i] = fmt.Sprintf("%v", x)
+		}
+	}
+	return ss
+}
+
+// jsonField returns a field with the given values: integers become int64,
+// other numbers float64, booleans bool. Mixed types fall back to strings.
+func jsonField(name string, vs []interface{}) (*message.Field, error) {
+	typed := make([]interface{}, len(vs))
+	var kind, k byte
+	for i, v := range vs {
+		switch x := v.(type) {
+		case json.Number:
+			if n, err := strconv.ParseInt(string(x), 10, 64); err == nil {
+				typed[i], k = n, 'i'
+			} else {
+				f, err := x.Float64()
+				if err != nil {
+					return nil, fmt.Errorf("bad number %q in %s: %s", x, name, err)
+				}
+				typed[i], k = f, 'f'
+			}
+		case bool:
+			typed[i], k = x, 'b'
+		default:
+			typed[i], k = fmt.Sprintf("%v", x), 's'
+		}
+		switch {
+		case i == 0 || kind == k:
+			kind = k
+		case kind == 'i' && k == 'f' || kind == 'f' && k == 'i':
+			kind = 'f'
+		default:
+			kind = 's'
+		}
+	}
+	for i, v := range typed {
+		switch kind {
+		case 'f':
+			if n, ok := v.(int64); ok {
+				typed[i] = float64(n)
+			}
+		case 's':
+			typed[i] = jsonStrings(vs[i : i+1])[0]
+		}
+	}
+	return newField(name, "", typed)
+}
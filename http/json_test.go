@@ -0,0 +1,73 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"reflect"
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	msg := new(message.Message)
+	hsi := new(HTTPSimpleInput)
+	err := hsi.parseJSON(msg, []byte(`{"severity":3, "Logger":"app", "msg":"hello",
+		"user":{"name":"x","id":12}, "latency":1.5, "ok":true,
+		"tags":["a","b"], "codes":[1,2.5], "mixed":[1,"a"], "none":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetSeverity() != 3 || msg.GetLogger() != "app" {
+		t.Errorf("well-known keys are not set: %s", msg)
+	}
+	if msg.GetPayload() == "" {
+		t.Errorf("the JSON should be the payload")
+	}
+	for k, want := range map[string]interface{}{
+		"msg":       []string{"hello"},
+		"user.name": []string{"x"},
+		"user.id":   []int64{12},
+		"latency":   []float64{1.5},
+		"ok":        []bool{true},
+		"tags":      []string{"a", "b"},
+		"codes":     []float64{1, 2.5},
+		"mixed":     []string{"1", "a"},
+	} {
+		f := msg.FindFirstField(k)
+		if f == nil {
+			t.Errorf("no field %s", k)
+			continue
+		}
+		var got interface{}
+		switch f.GetValueType() {
+		case message.Field_STRING:
+			got = f.GetValueString()
+		case message.Field_INTEGER:
+			got = f.GetValueInteger()
+		case message.Field_DOUBLE:
+			got = f.GetValueDouble()
+		case message.Field_BOOL:
+			got = f.GetValueBool()
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %#v, wanted %#v", k, got, want)
+		}
+	}
+	if f := msg.FindFirstField("none"); f != nil {
+		t.Errorf("null should be skipped, got %s", f)
+	}
+
+	if err = hsi.parseJSON(new(message.Message), []byte(`[1,2]`)); err == nil {
+		t.Errorf("arrays should be rejected")
+	}
+}
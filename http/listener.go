@@ -0,0 +1,135 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd
+const listenFdsStart = 3
+
+// SocketConfig holds the settings of a Unix domain socket
+type SocketConfig struct {
+	// Mode is the octal permission of the socket file (e.g. "0660")
+	Mode  string `toml:"mode"`
+	Owner string `toml:"owner"`
+	Group string `toml:"group"`
+}
+
+// listen returns the listener for the address:
+// "unix:/path" is a Unix domain socket, "systemd" (or "systemd:name")
+// is a socket passed by systemd socket activation, anything else is TCP.
+func listen(address string, sc SocketConfig) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		return listenUnix(address[5:], sc)
+	case address == "systemd" || strings.HasPrefix(address, "systemd:"):
+		return listenSystemd(strings.TrimPrefix(strings.TrimPrefix(address, "systemd"), ":"))
+	}
+	return net.Listen("tcp", address)
+}
+
+// listenUnix listens on the Unix domain socket at path, removing
+// the stale socket file left there
+func listenUnix(path string, sc SocketConfig) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = sc.apply(path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// apply sets the mode and the owner of the socket file
+func (sc SocketConfig) apply(path string) error {
+	if sc.Mode != "" {
+		mode, err := strconv.ParseUint(sc.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("error parsing socket mode %q: %s", sc.Mode, err)
+		}
+		if err = os.Chmod(path, os.FileMode(mode)); err != nil {
+			return fmt.Errorf("error setting mode of %s: %s", path, err)
+		}
+	}
+	if sc.Owner == "" && sc.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if sc.Owner != "" {
+		u, err := user.Lookup(sc.Owner)
+		if err != nil {
+			return fmt.Errorf("error looking up user %q: %s", sc.Owner, err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("bad uid %q of %s: %s", u.Uid, sc.Owner, err)
+		}
+	}
+	if sc.Group != "" {
+		g, err := user.LookupGroup(sc.Group)
+		if err != nil {
+			return fmt.Errorf("error looking up group %q: %s", sc.Group, err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("bad gid %q of %s: %s", g.Gid, sc.Group, err)
+		}
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("error setting owner of %s: %s", path, err)
+	}
+	return nil
+}
+
+// listenSystemd returns the listener passed by systemd (LISTEN_FDS),
+// the one named name (by LISTEN_FDNAMES), or the first if name is empty
+func listenSystemd(name string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets passed by systemd (LISTEN_PID=%q)", os.Getenv("LISTEN_PID"))
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("no sockets passed by systemd (LISTEN_FDS=%q)", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		if name != "" && (i >= len(names) || names[i] != name) {
+			continue
+		}
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("systemd-fd-%d", fd))
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener dups the descriptor
+		if err != nil {
+			return nil, fmt.Errorf("error using fd %d passed by systemd: %s", fd, err)
+		}
+		return ln, nil
+	}
+	return nil, fmt.Errorf("no socket named %q passed by systemd (LISTEN_FDNAMES=%q)",
+		name, os.Getenv("LISTEN_FDNAMES"))
+}
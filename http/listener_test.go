@@ -0,0 +1,51 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hsi-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hekad.sock")
+
+	ln, err := listen("unix:"+path, SocketConfig{Mode: "0600"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModePerm != 0600 {
+		t.Errorf("got mode %s", fi.Mode())
+	}
+	if _, err = listen("unix:"+path, SocketConfig{}); err == nil {
+		t.Errorf("socket in use should not be taken over")
+	}
+	ln.Close()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file should be removed on close (%v)", err)
+	}
+
+	if _, err = listen("systemd", SocketConfig{}); err == nil {
+		t.Errorf("systemd without LISTEN_FDS should fail")
+	}
+}
@@ -0,0 +1,319 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/klauspost/compress/snappy"
+	"github.com/mozilla-services/heka/message"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// lokiStream is a stream of a Loki push request
+type lokiStream struct {
+	Labels  map[string]string
+	Entries []lokiEntry
+}
+
+// lokiEntry is one log line of a stream
+type lokiEntry struct {
+	Timestamp int64
+	Line      string
+	Metadata  map[string]string
+}
+
+// decodeLoki decodes a push request, as JSON or as snappy compressed protobuf
+func decodeLoki(body []byte, isJSON bool) ([]lokiStream, error) {
+	if isJSON {
+		return decodeLokiJSON(body)
+	}
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing snappy body: %s", err)
+	}
+	var streams []lokiStream
+	err = protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 {
+			return 0, nil
+		}
+		v, n := protoBytes(typ, b)
+		if n < 0 {
+			return n, nil
+		}
+		var s lokiStream
+		if err := s.unmarshal(v); err != nil {
+			return 0, err
+		}
+		streams = append(streams, s)
+		return n, nil
+	})
+	return streams, err
+}
+
+// decodeLokiJSON decodes {"streams":[{"stream":{...},"values":[["ns","line",{...}]]}]}
+func decodeLokiJSON(body []byte) ([]lokiStream, error) {
+	var req struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("error parsing Loki JSON: %s", err)
+	}
+	streams := make([]lokiStream, len(req.Streams))
+	for i, s := range req.Streams {
+		streams[i] = lokiStream{Labels: s.Stream, Entries: make([]lokiEntry, len(s.Values))}
+		for j, v := range s.Values {
+			if len(v) < 2 || len(v) > 3 {
+				return nil, fmt.Errorf("stream %d value %d: need [timestamp, line(, metadata)]", i, j)
+			}
+			var ts string
+			e := &streams[i].Entries[j]
+			if err := json.Unmarshal(v[0], &ts); err != nil {
+				return nil, fmt.Errorf("stream %d value %d: bad timestamp: %s", i, j, err)
+			}
+			var err error
+			if e.Timestamp, err = strconv.ParseInt(ts, 10, 64); err != nil {
+				return nil, fmt.Errorf("stream %d value %d: bad timestamp: %s", i, j, err)
+			}
+			if err = json.Unmarshal(v[1], &e.Line); err != nil {
+				return nil, fmt.Errorf("stream %d value %d: bad line: %s", i, j, err)
+			}
+			if len(v) == 3 {
+				if err = json.Unmarshal(v[2], &e.Metadata); err != nil {
+					return nil, fmt.Errorf("stream %d value %d: bad metadata: %s", i, j, err)
+				}
+			}
+		}
+	}
+	return streams, nil
+}
+
+// unmarshal decodes a StreamAdapter
+func (s *lokiStream) unmarshal(b []byte) error {
+	return protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 && num != 2 {
+			return 0, nil
+		}
+		v, n := protoBytes(typ, b)
+		if n < 0 {
+			return n, nil
+		}
+		if num == 1 {
+			labels, err := parseLokiLabels(string(v))
+			if err != nil {
+				return 0, err
+			}
+			s.Labels = labels
+			return n, nil
+		}
+		var e lokiEntry
+		if err := e.unmarshal(v); err != nil {
+			return 0, err
+		}
+		s.Entries = append(s.Entries, e)
+		return n, nil
+	})
+}
+
+// unmarshal decodes an EntryAdapter
+func (e *lokiEntry) unmarshal(b []byte) error {
+	return protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num < 1 || num > 3 {
+			return 0, nil
+		}
+		v, n := protoBytes(typ, b)
+		if n < 0 {
+			return n, nil
+		}
+		switch num {
+		case 1:
+			var sec, nsec uint64
+			err := protoFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				if typ != protowire.VarintType || num != 1 && num != 2 {
+					return 0, nil
+				}
+				x, n := protowire.ConsumeVarint(b)
+				if num == 1 {
+					sec = x
+				} else {
+					nsec = x
+				}
+				return n, nil
+			})
+			if err != nil {
+				return 0, err
+			}
+			e.Timestamp = int64(sec)*int64(time.Second) + int64(nsec)
+		case 2:
+			e.Line = string(v)
+		case 3:
+			var name, value string
+			err := protoFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				if num != 1 && num != 2 {
+					return 0, nil
+				}
+				s, n := protoBytes(typ, b)
+				if num == 1 {
+					name = string(s)
+				} else {
+					value = string(s)
+				}
+				return n, nil
+			})
+			if err != nil {
+				return 0, err
+			}
+			if e.Metadata == nil {
+				e.Metadata = make(map[string]string)
+			}
+			e.Metadata[name] = value
+		}
+		return n, nil
+	})
+}
+
+// parseLokiLabels parses the {name="value", ...} form of the labels
+func parseLokiLabels(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return nil, fmt.Errorf("bad labels %q", s)
+	}
+	labels := make(map[string]string)
+	rest := strings.TrimSpace(s[1 : len(s)-1])
+	for rest != "" {
+		i := strings.IndexByte(rest, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("bad labels %q", s)
+		}
+		name := strings.TrimSpace(rest[:i])
+		rest = strings.TrimSpace(rest[i+1:])
+		q, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, fmt.Errorf("bad labels %q: %s", s, err)
+		}
+		if labels[name], err = strconv.Unquote(q); err != nil {
+			return nil, fmt.Errorf("bad labels %q: %s", s, err)
+		}
+		rest = strings.TrimSpace(rest[len(q):])
+		if rest != "" {
+			if rest[0] != ',' {
+				return nil, fmt.Errorf("bad labels %q", s)
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+	}
+	return labels, nil
+}
+
+// lokiMessage sets the message from the entry: the line is the payload,
+// the labels and the structured metadata are fields;
+// the host (or hostname) label is the Hostname, job the Logger
+func lokiMessage(msg *message.Message, labels map[string]string, e lokiEntry) {
+	msg.SetPayload(e.Line)
+	msg.SetTimestamp(e.Timestamp)
+	if msg.Type == nil {
+		msg.SetType("loki")
+	}
+	for _, m := range []map[string]string{labels, e.Metadata} {
+		names := make([]string, 0, len(m))
+		for k := range m {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			addStringField(msg, k, m[k])
+		}
+	}
+	if h := labels["host"]; h != "" {
+		msg.SetHostname(h)
+	} else if h = labels["hostname"]; h != "" {
+		msg.SetHostname(h)
+	}
+	if j := labels["job"]; j != "" && msg.Logger == nil {
+		msg.SetLogger(j)
+	}
+}
+
+// handleLoki injects the entries of a Loki push request as messages,
+// with tmpl's values as defaults. If not all the entries can be injected,
+// the client is asked to retry the whole request.
+func (hsi *HTTPSimpleInput) handleLoki(w http.ResponseWriter, req request, tmpl *message.Message, isJSON bool) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		atomic.AddInt64(&hsi.stats.Rejected, 1)
+		w.WriteHeader(bodyErrCode(err))
+		fmt.Fprintf(w, "error reading body: %s\n", err)
+		return
+	}
+	streams, err := decodeLoki(body, isJSON)
+	if err != nil {
+		atomic.AddInt64(&hsi.stats.DecodeFailed, 1)
+		atomic.AddInt64(&hsi.stats.Rejected, 1)
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "%s\n", err)
+		return
+	}
+	tmpl.Uuid, tmpl.Payload = nil, nil
+
+	var total, accepted int
+	var injectErr, invalid error
+	var invalids int
+	for _, s := range streams {
+		for _, e := range s.Entries {
+			total++
+			if injectErr != nil {
+				continue
+			}
+			msg := message.CopyMessage(tmpl)
+			lokiMessage(msg, s.Labels, e)
+			_, injectErr = hsi.injectMessage(w, msg, req)
+			if _, ok := injectErr.(schemaError); ok {
+				atomic.AddInt64(&hsi.stats.Rejected, 1)
+				if invalids++; invalid == nil {
+					invalid = injectErr
+				}
+				injectErr = nil
+				continue
+			}
+			if injectErr == nil {
+				accepted++
+			}
+		}
+	}
+	if injectErr != nil {
+		atomic.AddInt64(&hsi.stats.Rejected, int64(total-accepted-invalids))
+		if w.Header().Get("Retry-After") == "" {
+			setRetryAfter(w, time.Second)
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "%d of %d entries accepted: %s\n", accepted, total, injectErr)
+		return
+	}
+	if invalid != nil {
+		// as Loki does, the valid entries are kept, and the client should not retry
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "%d of %d entries invalid: %s\n", invalids, total, invalid)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,94 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseLokiLabels(t *testing.T) {
+	labels, err := parseLokiLabels(`{job="varlogs", host="web-1",msg="a \"quoted\", value"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 3 || labels["job"] != "varlogs" || labels["host"] != "web-1" ||
+		labels["msg"] != `a "quoted", value` {
+		t.Errorf("got %v", labels)
+	}
+	for _, s := range []string{``, `job="x"`, `{job=x}`, `{job="x" host="y"}`} {
+		if _, err = parseLokiLabels(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestLoki(t *testing.T) {
+	hsi, input := newTestInput(2)
+	hsi.lokiPath = "/loki/api/v1/push"
+
+	post := func(ct, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/loki/api/v1/push", strings.NewReader(body))
+		r.Header.Set("Content-Type", ct)
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w
+	}
+
+	w := post("application/json", `{"streams": [{"stream": {"job": "nginx", "host": "web-1"},
+		"values": [["1700000000000000000", "GET /", {"trace_id": "abc"}]]}]}`)
+	if w.Code != 204 {
+		t.Fatalf("json: got %d: %s", w.Code, w.Body)
+	}
+	m := (<-input).Message
+	if m.GetPayload() != "GET /" || m.GetType() != "loki" || m.GetLogger() != "nginx" ||
+		m.GetHostname() != "web-1" || m.GetTimestamp() != 1700000000000000000 {
+		t.Errorf("json: got %s", m)
+	}
+	if f := m.FindFirstField("trace_id"); f == nil || f.GetValue() != "abc" {
+		t.Errorf("json: field trace_id is %v", f)
+	}
+
+	ts := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1700000000)
+	ts = protowire.AppendVarint(protowire.AppendTag(ts, 2, protowire.VarintType), 5)
+	entry := protoMsg(protoMsg(nil, 1, ts), 2, []byte("hello"))
+	entry = protoMsg(entry, 3, protoMsg(protoMsg(nil, 1, []byte("user")), 2, []byte("joe")))
+	stream := protoMsg(protoMsg(nil, 1, []byte(`{job="app"}`)), 2, entry)
+	w = post("application/x-protobuf", string(snappy.Encode(nil, protoMsg(nil, 1, stream))))
+	if w.Code != 204 {
+		t.Fatalf("protobuf: got %d: %s", w.Code, w.Body)
+	}
+	m = (<-input).Message
+	if m.GetPayload() != "hello" || m.GetLogger() != "app" || m.GetTimestamp() != 1700000000000000005 {
+		t.Errorf("protobuf: got %s", m)
+	}
+	if f := m.FindFirstField("user"); f == nil || f.GetValue() != "joe" {
+		t.Errorf("protobuf: field user is %v", f)
+	}
+
+	if w = post("application/x-protobuf", "not snappy"); w.Code != 400 {
+		t.Errorf("bad protobuf: got %d", w.Code)
+	}
+	if w = post("application/json", `{"streams": [{"values": [["x", "y"]]}]}`); w.Code != 400 {
+		t.Errorf("bad timestamp: got %d", w.Code)
+	}
+	// no more packs: the client should retry
+	w = post("application/json", `{"streams": [{"stream": {}, "values": [["1", "a"]]}]}`)
+	if w.Code != 429 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("no packs: got %d %v: %s", w.Code, w.Header(), w.Body)
+	}
+}
@@ -0,0 +1,138 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies parses the IP addresses and CIDR ranges
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if strings.IndexByte(p, '/') < 0 {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("bad trusted proxy address %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted proxy range %q: %s", p, err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// isTrusted reports whether the address is one of the trusted proxies
+func (hsi *HTTPSimpleInput) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range hsi.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client: if the request comes from
+// a trusted proxy, the last untrusted address of the Forwarded (or else the
+// X-Forwarded-For) header.
+func (hsi *HTTPSimpleInput) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !hsi.isTrusted(ip) {
+		return ip
+	}
+	hops := forwardedFor(r.Header["Forwarded"])
+	if len(hops) == 0 {
+		for _, h := range r.Header["X-Forwarded-For"] {
+			for _, hop := range strings.Split(h, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == "" {
+			continue
+		}
+		ip = hops[i]
+		if !hsi.isTrusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// forwardedFor returns the "for" addresses of the RFC 7239 Forwarded headers,
+// without ports and brackets
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, h := range headers {
+		for _, elt := range strings.Split(h, ",") {
+			for _, pair := range strings.Split(elt, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) < 4 || !strings.EqualFold(pair[:4], "for=") {
+					continue
+				}
+				addr := strings.Trim(pair[4:], `"`)
+				if host, _, err := net.SplitHostPort(addr); err == nil {
+					addr = host
+				}
+				hops = append(hops, strings.Trim(addr, "[]"))
+			}
+		}
+	}
+	return hops
+}
+
+// addMetadata adds the request's data as fields: the client's address,
+// user agent, path and the allowed headers; the client's address is the
+// Hostname if it is not set
+func (hsi *HTTPSimpleInput) addMetadata(msg *message.Message, req request) {
+	if msg.Hostname == nil {
+		if net.ParseIP(req.clientIP) != nil {
+			msg.SetHostname(req.clientIP)
+		} else {
+			// Unix domain socket, or an obfuscated client
+			msg.SetHostname(req.Host)
+		}
+	}
+	add := func(name, value string) {
+		if value == "" {
+			return
+		}
+		if f, e := message.NewField(name, value, ""); e == nil {
+			msg.AddField(f)
+		}
+	}
+	add("RemoteAddr", req.clientIP)
+	add("UserAgent", req.UserAgent())
+	add("RequestPath", req.URL.Path)
+	for _, h := range hsi.headers {
+		add("Header."+h, req.Header.Get(h))
+	}
+}
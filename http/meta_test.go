@@ -0,0 +1,74 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	hsi := new(HTTPSimpleInput)
+	var err error
+	if hsi.trustedProxies, err = parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range []struct {
+		remote, xff, fwd string
+		ip               string
+	}{
+		{"198.51.100.7:1234", "1.2.3.4", "", "198.51.100.7"},
+		{"192.0.2.1:1234", "1.2.3.4, 10.1.1.1", "", "1.2.3.4"},
+		{"192.0.2.1:1234", "6.6.6.6, 1.2.3.4", "", "1.2.3.4"},
+		{"10.0.0.1:1234", "1.2.3.4", `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`, "2001:db8::1"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+	} {
+		r, _ := http.NewRequest("POST", "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.fwd != "" {
+			r.Header.Set("Forwarded", tc.fwd)
+		}
+		if got := hsi.clientIP(r); got != tc.ip {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.ip)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	hsi, input := newTestInput(1)
+	hsi.headers = []string{"X-Request-Id"}
+	r, _ := http.NewRequest("POST", "/app/x?payload=p", nil)
+	r.RemoteAddr = "198.51.100.7:1234"
+	r.Header.Set("User-Agent", "curl/8.0")
+	r.Header.Set("X-Request-Id", "abc")
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 201 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	m := (<-input).Message
+	if m.GetHostname() != "198.51.100.7" {
+		t.Errorf("got hostname %q", m.GetHostname())
+	}
+	for k, v := range map[string]string{
+		"RemoteAddr": "198.51.100.7", "UserAgent": "curl/8.0",
+		"RequestPath": "/app/x", "Header.X-Request-Id": "abc",
+	} {
+		if f := m.FindFirstField(k); f == nil || f.GetValueString()[0] != v {
+			t.Errorf("field %s is %v, wanted %q", k, f, v)
+		}
+	}
+}
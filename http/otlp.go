@@ -0,0 +1,717 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The OTLP logs request (opentelemetry/proto/collector/logs/v1), with the
+// fields used. The JSON tags follow the OTLP/JSON encoding.
+type otlpLogs struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         otlpUint64     `json:"timeUnixNano"`
+	ObservedTimeUnixNano otlpUint64     `json:"observedTimeUnixNano"`
+	SeverityNumber       int32          `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+	TraceID              string         `json:"traceId"` // hex
+	SpanID               string         `json:"spanId"`  // hex
+	EventName            string         `json:"eventName"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string     `json:"stringValue"`
+	BoolValue   *bool       `json:"boolValue"`
+	IntValue    *otlpUint64 `json:"intValue"`
+	DoubleValue *float64    `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue []byte `json:"bytesValue"`
+}
+
+// otlpUint64 is a 64-bit integer, which OTLP/JSON may send as a string
+type otlpUint64 uint64
+
+func (u *otlpUint64) UnmarshalJSON(p []byte) error {
+	s := strings.Trim(string(p), `"`)
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		*u = otlpUint64(n)
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad integer %s: %s", p, err)
+	}
+	*u = otlpUint64(n)
+	return nil
+}
+
+// value returns the Go value of the AnyValue
+func (v otlpAnyValue) value() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.BytesValue != nil:
+		return v.BytesValue
+	case v.ArrayValue != nil:
+		vs := make([]interface{}, len(v.ArrayValue.Values))
+		for i, sub := range v.ArrayValue.Values {
+			vs[i] = sub.value()
+		}
+		return vs
+	case v.KvlistValue != nil:
+		m := make(map[string]interface{}, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			m[kv.Key] = kv.Value.value()
+		}
+		return m
+	}
+	return nil
+}
+
+// errOTLPWire is returned for a malformed OTLP protobuf message
+var errOTLPWire = errors.New("malformed OTLP protobuf")
+
+// maxOTLPDepth limits the nesting of the array and kvlist values
+const maxOTLPDepth = 100
+
+var errOTLPDepth = fmt.Errorf("values nested deeper than %d", maxOTLPDepth)
+
+// checkDepth returns errOTLPDepth if v is nested deeper than maxOTLPDepth,
+// counting from depth
+func (v otlpAnyValue) checkDepth(depth int) error {
+	if depth > maxOTLPDepth {
+		return errOTLPDepth
+	}
+	if v.ArrayValue != nil {
+		for _, sub := range v.ArrayValue.Values {
+			if err := sub.checkDepth(depth + 1); err != nil {
+				return err
+			}
+		}
+	}
+	if v.KvlistValue != nil {
+		for _, kv := range v.KvlistValue.Values {
+			if err := kv.Value.checkDepth(depth + 1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkDepth checks the depth of all the values of the request
+func (logs otlpLogs) checkDepth() error {
+	for _, rl := range logs.ResourceLogs {
+		for _, kv := range rl.Resource.Attributes {
+			if err := kv.Value.checkDepth(0); err != nil {
+				return err
+			}
+		}
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				if err := lr.Body.checkDepth(0); err != nil {
+					return err
+				}
+				for _, kv := range lr.Attributes {
+					if err := kv.Value.checkDepth(0); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// protoFields calls fn with each field of the protobuf message in b
+func protoFields(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errOTLPWire
+		}
+		b = b[n:]
+		m, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if m == 0 {
+			// not used: skip
+			m = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if m < 0 {
+			return errOTLPWire
+		}
+		b = b[m:]
+	}
+	return nil
+}
+
+// protoBytes returns the length-delimited value of a field
+func protoBytes(typ protowire.Type, b []byte) ([]byte, int) {
+	if typ != protowire.BytesType {
+		return nil, -1
+	}
+	return protowire.ConsumeBytes(b)
+}
+
+// unmarshalOTLP decodes an ExportLogsServiceRequest
+func unmarshalOTLP(b []byte) (otlpLogs, error) {
+	var logs otlpLogs
+	err := protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 {
+			return 0, nil
+		}
+		v, n := protoBytes(typ, b)
+		if n < 0 {
+			return n, nil
+		}
+		var rl otlpResourceLogs
+		if err := rl.unmarshal(v); err != nil {
+			return n, err
+		}
+		logs.ResourceLogs = append(logs.ResourceLogs, rl)
+		return n, nil
+	})
+	return logs, err
+}
+
+func (rl *otlpResourceLogs) unmarshal(b []byte) error {
+	return protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 && num != 2 {
+			return 0, nil
+		}
+		v, n := protoBytes(typ, b)
+		if n < 0 {
+			return n, nil
+		}
+		if num == 1 {
+			return n, protoFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				if num != 1 {
+					return 0, nil
+				}
+				return unmarshalKeyValue(&rl.Resource.Attributes, typ, b, 0)
+			})
+		}
+		var sl otlpScopeLogs
+		if err := sl.unmarshal(v); err != nil {
+			return n, err
+		}
+		rl.ScopeLogs = append(rl.ScopeLogs, sl)
+		return n, nil
+	})
+}
+
+func (sl *otlpScopeLogs) unmarshal(b []byte) error {
+	return protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 && num != 2 {
+			return 0, nil
+		}
+		v, n := protoBytes(typ, b)
+		if n < 0 {
+			return n, nil
+		}
+		if num == 1 {
+			return n, protoFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				if num != 1 && num != 2 {
+					return 0, nil
+				}
+				s, n := protoBytes(typ, b)
+				if num == 1 {
+					sl.Scope.Name = string(s)
+				} else {
+					sl.Scope.Version = string(s)
+				}
+				return n, nil
+			})
+		}
+		var lr otlpLogRecord
+		if err := lr.unmarshal(v); err != nil {
+			return n, err
+		}
+		sl.LogRecords = append(sl.LogRecords, lr)
+		return n, nil
+	})
+}
+
+func (lr *otlpLogRecord) unmarshal(b []byte) error {
+	return protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		var n int
+		switch num {
+		case 1, 11:
+			if typ != protowire.Fixed64Type {
+				return -1, nil
+			}
+			var t uint64
+			t, n = protowire.ConsumeFixed64(b)
+			if num == 1 {
+				lr.TimeUnixNano = otlpUint64(t)
+			} else {
+				lr.ObservedTimeUnixNano = otlpUint64(t)
+			}
+		case 2:
+			if typ != protowire.VarintType {
+				return -1, nil
+			}
+			var sev uint64
+			sev, n = protowire.ConsumeVarint(b)
+			lr.SeverityNumber = int32(sev)
+		case 3, 12:
+			var s []byte
+			s, n = protoBytes(typ, b)
+			if num == 3 {
+				lr.SeverityText = string(s)
+			} else {
+				lr.EventName = string(s)
+			}
+		case 5:
+			var v []byte
+			if v, n = protoBytes(typ, b); n >= 0 {
+				return n, lr.Body.unmarshal(v, 0)
+			}
+		case 6:
+			return unmarshalKeyValue(&lr.Attributes, typ, b, 0)
+		case 9, 10:
+			var id []byte
+			id, n = protoBytes(typ, b)
+			if num == 9 {
+				lr.TraceID = hex.EncodeToString(id)
+			} else {
+				lr.SpanID = hex.EncodeToString(id)
+			}
+		}
+		return n, nil
+	})
+}
+
+// unmarshalKeyValue appends the KeyValue in b to kvs;
+// depth is the nesting depth of its value
+func unmarshalKeyValue(kvs *[]otlpKeyValue, typ protowire.Type, b []byte, depth int) (int, error) {
+	v, n := protoBytes(typ, b)
+	if n < 0 {
+		return n, nil
+	}
+	var kv otlpKeyValue
+	err := protoFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 && num != 2 {
+			return 0, nil
+		}
+		v, n := protoBytes(typ, b)
+		if n < 0 {
+			return n, nil
+		}
+		if num == 1 {
+			kv.Key = string(v)
+			return n, nil
+		}
+		return n, kv.Value.unmarshal(v, depth)
+	})
+	*kvs = append(*kvs, kv)
+	return n, err
+}
+
+// unmarshal decodes an AnyValue nested depth levels deep
+func (av *otlpAnyValue) unmarshal(b []byte, depth int) error {
+	if depth > maxOTLPDepth {
+		return errOTLPDepth
+	}
+	return protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		var n int
+		switch num {
+		case 1:
+			var s []byte
+			if s, n = protoBytes(typ, b); n >= 0 {
+				str := string(s)
+				av.StringValue = &str
+			}
+		case 2, 3:
+			if typ != protowire.VarintType {
+				return -1, nil
+			}
+			var x uint64
+			x, n = protowire.ConsumeVarint(b)
+			if num == 2 {
+				t := x != 0
+				av.BoolValue = &t
+			} else {
+				i := otlpUint64(x)
+				av.IntValue = &i
+			}
+		case 4:
+			if typ != protowire.Fixed64Type {
+				return -1, nil
+			}
+			var x uint64
+			x, n = protowire.ConsumeFixed64(b)
+			f := math.Float64frombits(x)
+			av.DoubleValue = &f
+		case 5:
+			var v []byte
+			if v, n = protoBytes(typ, b); n < 0 {
+				return n, nil
+			}
+			av.ArrayValue = &struct {
+				Values []otlpAnyValue `json:"values"`
+			}{}
+			return n, protoFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				if num != 1 {
+					return 0, nil
+				}
+				v, n := protoBytes(typ, b)
+				if n < 0 {
+					return n, nil
+				}
+				var sub otlpAnyValue
+				err := sub.unmarshal(v, depth+1)
+				av.ArrayValue.Values = append(av.ArrayValue.Values, sub)
+				return n, err
+			})
+		case 6:
+			var v []byte
+			if v, n = protoBytes(typ, b); n < 0 {
+				return n, nil
+			}
+			av.KvlistValue = &struct {
+				Values []otlpKeyValue `json:"values"`
+			}{}
+			return n, protoFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				if num != 1 {
+					return 0, nil
+				}
+				return unmarshalKeyValue(&av.KvlistValue.Values, typ, b, depth+1)
+			})
+		case 7:
+			var v []byte
+			if v, n = protoBytes(typ, b); n >= 0 {
+				av.BytesValue = append([]byte{}, v...)
+			}
+		}
+		return n, nil
+	})
+}
+
+// otlpSeverity maps the OTLP severity number onto the syslog severity:
+// TRACE and DEBUG are debug (7), INFO info (6), WARN warning (4),
+// ERROR error (3), FATAL critical (2).
+func otlpSeverity(n int32) int32 {
+	switch {
+	case n <= 8:
+		return 7
+	case n <= 12:
+		return 6
+	case n <= 16:
+		return 4
+	case n <= 20:
+		return 3
+	}
+	return 2
+}
+
+// otlpMessage sets the message from the log record: the resource's
+// attributes go into "resource." fields, the record's attributes into
+// fields by their name; a non-string body is the payload as JSON.
+func otlpMessage(msg *message.Message, res otlpResource, scope otlpScope, lr otlpLogRecord) error {
+	values := make(map[string][]interface{}, len(res.Attributes)+len(lr.Attributes)+8)
+	for _, kv := range res.Attributes {
+		flattenOTLP(values, "resource."+kv.Key, kv.Value.value())
+	}
+	for _, kv := range lr.Attributes {
+		flattenOTLP(values, kv.Key, kv.Value.value())
+	}
+	for k, v := range map[string]string{
+		"trace_id": lr.TraceID, "span_id": lr.SpanID,
+		"severity_text": lr.SeverityText, "event_name": lr.EventName,
+		"scope.name": scope.Name, "scope.version": scope.Version,
+	} {
+		if v != "" {
+			values[k] = []interface{}{v}
+		}
+	}
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		f, err := otlpField(k, values[k])
+		if err != nil {
+			return err
+		}
+		msg.AddField(f)
+	}
+
+	if ts := lr.TimeUnixNano; ts != 0 {
+		msg.SetTimestamp(int64(ts))
+	} else if ts = lr.ObservedTimeUnixNano; ts != 0 {
+		msg.SetTimestamp(int64(ts))
+	}
+	if lr.SeverityNumber > 0 {
+		msg.SetSeverity(otlpSeverity(lr.SeverityNumber))
+	}
+	for _, kv := range res.Attributes {
+		switch kv.Key {
+		case "host.name":
+			if s, ok := kv.Value.value().(string); ok {
+				msg.SetHostname(s)
+			}
+		case "process.pid":
+			if i, ok := kv.Value.value().(int64); ok {
+				msg.SetPid(int32(i))
+			}
+		case "service.name":
+			if s, ok := kv.Value.value().(string); ok && msg.Logger == nil {
+				msg.SetLogger(s)
+			}
+		}
+	}
+	if msg.Logger == nil && scope.Name != "" {
+		msg.SetLogger(scope.Name)
+	}
+	if msg.Type == nil {
+		msg.SetType("otlp.log")
+	}
+	switch body := lr.Body.value().(type) {
+	case nil:
+	case string:
+		msg.SetPayload(body)
+	default:
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("cannot encode body: %s", err)
+		}
+		msg.SetPayload(string(b))
+	}
+	return nil
+}
+
+// flattenOTLP collects the values of v under dotted names, as flattenJSON
+func flattenOTLP(values map[string][]interface{}, name string, v interface{}) {
+	switch x := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, sub := range x {
+			flattenOTLP(values, name+"."+k, sub)
+		}
+	case []interface{}:
+		for _, sub := range x {
+			flattenOTLP(values, name, sub)
+		}
+	default:
+		values[name] = append(values[name], x)
+	}
+}
+
+// otlpField returns the field of the values, falling back to strings
+// for mixed types
+func otlpField(name string, vs []interface{}) (*message.Field, error) {
+	same := true
+	for _, v := range vs[1:] {
+		if fmt.Sprintf("%T", v) != fmt.Sprintf("%T", vs[0]) {
+			same = false
+			break
+		}
+	}
+	if !same {
+		ss := make([]interface{}, len(vs))
+		for i, v := range vs {
+			if b, ok := v.([]byte); ok {
+				ss[i] = base64.StdEncoding.EncodeToString(b)
+			} else {
+				ss[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		vs = ss
+	}
+	return newField(name, "", vs)
+}
+
+// decodeOTLP decodes the OTLP/HTTP logs request, protobuf or JSON
+func decodeOTLP(body []byte, isJSON bool) (otlpLogs, error) {
+	if !isJSON {
+		logs, err := unmarshalOTLP(body)
+		if err != nil {
+			return logs, fmt.Errorf("error decoding OTLP logs: %s", err)
+		}
+		return logs, nil
+	}
+	var logs otlpLogs
+	if err := json.Unmarshal(body, &logs); err != nil {
+		return logs, fmt.Errorf("error decoding OTLP/JSON logs: %s", err)
+	}
+	if err := logs.checkDepth(); err != nil {
+		return logs, fmt.Errorf("error decoding OTLP/JSON logs: %s", err)
+	}
+	return logs, nil
+}
+
+// otlpResponse encodes the ExportLogsServiceResponse, with the partial
+// success if some records were rejected
+func otlpResponse(rejected int, errMsg string, isJSON bool) []byte {
+	if isJSON {
+		if rejected == 0 {
+			return []byte("{}\n")
+		}
+		b, _ := json.Marshal(map[string]interface{}{"partialSuccess": map[string]interface{}{
+			"rejectedLogRecords": strconv.Itoa(rejected), "errorMessage": errMsg}})
+		return append(b, '\n')
+	}
+	if rejected == 0 {
+		return []byte{}
+	}
+	var ps []byte
+	ps = protowire.AppendTag(ps, 1, protowire.VarintType)
+	ps = protowire.AppendVarint(ps, uint64(rejected))
+	ps = protowire.AppendTag(ps, 2, protowire.BytesType)
+	ps = protowire.AppendString(ps, errMsg)
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, ps)
+}
+
+// handleOTLP injects the log records of an OTLP/HTTP logs request as
+// messages, with tmpl's values as defaults, and answers as OTLP/HTTP:
+// 200 with the number of the records rejected (as invalid, or not
+// injected after some were), 400 for a bad request, 503 (to be retried)
+// if none of the records could be injected.
+func (hsi *HTTPSimpleInput) handleOTLP(w http.ResponseWriter, req request, tmpl *message.Message, isJSON bool) {
+	ct := "application/x-protobuf"
+	if isJSON {
+		ct = "application/json"
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		atomic.AddInt64(&hsi.stats.Rejected, 1)
+		w.WriteHeader(bodyErrCode(err))
+		fmt.Fprintf(w, "error reading body: %s\n", err)
+		return
+	}
+	logs, err := decodeOTLP(body, isJSON)
+	if err != nil {
+		atomic.AddInt64(&hsi.stats.DecodeFailed, 1)
+		atomic.AddInt64(&hsi.stats.Rejected, 1)
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "%s\n", err)
+		return
+	}
+	tmpl.Uuid, tmpl.Payload = nil, nil
+
+	var total, accepted, failed int
+	var errMsg string
+	var injectErr error
+	for _, rl := range logs.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				total++
+				if injectErr != nil {
+					continue
+				}
+				msg := message.CopyMessage(tmpl)
+				if err = otlpMessage(msg, rl.Resource, sl.Scope, lr); err != nil {
+					atomic.AddInt64(&hsi.stats.DecodeFailed, 1)
+					atomic.AddInt64(&hsi.stats.Rejected, 1)
+					failed++
+					if errMsg == "" {
+						errMsg = err.Error()
+					}
+					continue
+				}
+				if _, injectErr = hsi.injectMessage(w, msg, req); injectErr != nil {
+					if _, ok := injectErr.(schemaError); ok {
+						atomic.AddInt64(&hsi.stats.Rejected, 1)
+						failed++
+						if errMsg == "" {
+							errMsg = injectErr.Error()
+						}
+						injectErr = nil
+						continue
+					}
+					// count the rest, but do not inject them
+					continue
+				}
+				accepted++
+			}
+		}
+	}
+	if injectErr != nil {
+		atomic.AddInt64(&hsi.stats.Rejected, int64(total-accepted-failed))
+		if w.Header().Get("Retry-After") == "" {
+			setRetryAfter(w, time.Second)
+		}
+		if accepted == 0 {
+			// nothing injected, the whole request can be retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "0 of %d records accepted: %s\n", total, injectErr)
+			return
+		}
+		// a retry would duplicate the injected records, so report the
+		// rest as rejected
+		errMsg = fmt.Sprintf("%d of %d records not injected: %s",
+			total-accepted-failed, total, injectErr)
+	}
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(200)
+	w.Write(otlpResponse(total-accepted, errMsg, isJSON))
+}
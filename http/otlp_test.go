@@ -0,0 +1,168 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// protoMsg appends a length-delimited field
+func protoMsg(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func protoKV(key string, value []byte) []byte {
+	return protoMsg(protoMsg(nil, 1, []byte(key)), 2, value)
+}
+
+func TestOTLP(t *testing.T) {
+	hsi, input := newTestInput(4)
+	hsi.otlpPath = "/v1/logs"
+
+	post := func(ct, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/v1/logs", strings.NewReader(body))
+		r.Header.Set("Content-Type", ct)
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w
+	}
+
+	jsonBody := `{"resourceLogs": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "checkout"}},
+			{"key": "host.name", "value": {"stringValue": "web-1"}}]},
+		"scopeLogs": [{"scope": {"name": "app.logger"}, "logRecords": [{
+			"timeUnixNano": "1700000000000000000",
+			"severityNumber": 17, "severityText": "ERROR",
+			"traceId": "5b8efff798038103d269b633813fc60c", "spanId": "eee19b7ec3c1b174",
+			"body": {"stringValue": "payment failed"},
+			"attributes": [
+				{"key": "http.status", "value": {"intValue": "502"}},
+				{"key": "retry", "value": {"boolValue": true}},
+				{"key": "ctx", "value": {"kvlistValue": {"values": [{"key": "user", "value": {"stringValue": "joe"}}]}}}]
+		}]}]}]}`
+	w := post("application/json", jsonBody)
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != "{}" {
+		t.Fatalf("json: got %d: %s", w.Code, w.Body)
+	}
+	m := (<-input).Message
+	if m.GetPayload() != "payment failed" || m.GetSeverity() != 3 || m.GetHostname() != "web-1" ||
+		m.GetLogger() != "checkout" || m.GetType() != "otlp.log" || m.GetTimestamp() != 1700000000000000000 {
+		t.Errorf("json: got %s", m)
+	}
+	for k, v := range map[string]interface{}{
+		"resource.service.name": "checkout", "trace_id": "5b8efff798038103d269b633813fc60c",
+		"span_id": "eee19b7ec3c1b174", "http.status": int64(502), "retry": true,
+		"ctx.user": "joe", "scope.name": "app.logger",
+	} {
+		if f := m.FindFirstField(k); f == nil || f.GetValue() != v {
+			t.Errorf("json: field %s is %v, wanted %v", k, f, v)
+		}
+	}
+
+	// the same in protobuf
+	traceID := []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c}
+	lr := protowire.AppendTag(nil, 1, protowire.Fixed64Type)
+	lr = protowire.AppendFixed64(lr, 1700000000000000000)
+	lr = protowire.AppendTag(lr, 2, protowire.VarintType)
+	lr = protowire.AppendVarint(lr, 9)
+	lr = protoMsg(lr, 5, protoMsg(nil, 1, []byte("hello")))
+	intValue := protowire.AppendVarint(protowire.AppendTag(nil, 3, protowire.VarintType), 42)
+	lr = protoMsg(lr, 6, protoKV("n", intValue))
+	lr = protoMsg(lr, 9, traceID)
+	sl := protoMsg(protoMsg(nil, 1, protoMsg(nil, 1, []byte("scope"))), 2, lr)
+	res := protoMsg(nil, 1, protoKV("host.name", protoMsg(nil, 1, []byte("web-2"))))
+	req := protoMsg(nil, 1, append(protoMsg(nil, 1, res), protoMsg(nil, 2, sl)...))
+	w = post("application/x-protobuf", string(req))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/x-protobuf" || w.Body.Len() != 0 {
+		t.Fatalf("protobuf: got %d %v: %q", w.Code, w.Header(), w.Body)
+	}
+	m = (<-input).Message
+	if m.GetPayload() != "hello" || m.GetSeverity() != 6 || m.GetHostname() != "web-2" || m.GetLogger() != "scope" {
+		t.Errorf("protobuf: got %s", m)
+	}
+	if f := m.FindFirstField("n"); f == nil || f.GetValue() != int64(42) {
+		t.Errorf("protobuf: field n is %v", f)
+	}
+	if f := m.FindFirstField("trace_id"); f == nil || f.GetValue() != "5b8efff798038103d269b633813fc60c" {
+		t.Errorf("protobuf: field trace_id is %v", f)
+	}
+
+	if w = post("application/x-protobuf", "\xff\xff"); w.Code != 400 {
+		t.Errorf("bad protobuf: got %d", w.Code)
+	}
+	// one pack for two records: the second is rejected, not retried
+	<-hsi.packs
+	twoRecords := strings.Replace(jsonBody, `"logRecords": [{`,
+		`"logRecords": [{"body": {"stringValue": "first"}}, {`, 1)
+	if w = post("application/json", twoRecords); w.Code != 200 || w.Header().Get("Retry-After") == "" ||
+		!bytes.Contains(w.Body.Bytes(), []byte(`"rejectedLogRecords":"1"`)) ||
+		!bytes.Contains(w.Body.Bytes(), []byte("1 of 2 records not injected")) {
+		t.Errorf("one pack: got %d %v: %s", w.Code, w.Header(), w.Body)
+	}
+	if m = (<-input).Message; m.GetPayload() != "first" {
+		t.Errorf("one pack: got %s", m)
+	}
+	// no more packs
+	hsi.packs = nil
+	if w = post("application/json", jsonBody); w.Code != 503 || !bytes.Contains(w.Body.Bytes(), []byte("pack")) {
+		t.Errorf("no packs: got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestOTLPDepth(t *testing.T) {
+	hsi, input := newTestInput(4)
+	hsi.otlpPath = "/v1/logs"
+
+	// nested returns the body nested n levels deep, in arrays and kvlists
+	nested := func(n int) (pb []byte, js string) {
+		pb, js = protoMsg(nil, 1, []byte("x")), `{"stringValue": "x"}`
+		for i := 0; i < n; i++ {
+			if i%2 == 0 {
+				pb = protoMsg(nil, 5, protoMsg(nil, 1, pb))
+				js = `{"arrayValue": {"values": [` + js + `]}}`
+			} else {
+				pb = protoMsg(nil, 6, protoMsg(nil, 1, protoKV("k", pb)))
+				js = `{"kvlistValue": {"values": [{"key": "k", "value": ` + js + `}]}}`
+			}
+		}
+		lr := protoMsg(protoMsg(nil, 5, pb), 6, protoKV("attr", pb))
+		pb = protoMsg(nil, 1, protoMsg(nil, 2, protoMsg(nil, 2, lr)))
+		js = `{"resourceLogs": [{"scopeLogs": [{"logRecords": [{"body": ` + js +
+			`, "attributes": [{"key": "attr", "value": ` + js + `}]}]}]}]}`
+		return pb, js
+	}
+	for _, tc := range []struct {
+		depth, code int
+	}{{maxOTLPDepth, 200}, {maxOTLPDepth + 1, 400}} {
+		pb, js := nested(tc.depth)
+		for ct, body := range map[string]string{"application/x-protobuf": string(pb), "application/json": js} {
+			r, _ := http.NewRequest("POST", "/v1/logs", strings.NewReader(body))
+			r.Header.Set("Content-Type", ct)
+			w := httptest.NewRecorder()
+			hsi.handler(w, r)
+			if w.Code != tc.code {
+				t.Errorf("%s nested %d deep: got %d: %s", ct, tc.depth, w.Code, w.Body)
+			}
+			if w.Code == 200 {
+				<-input
+			}
+		}
+	}
+}
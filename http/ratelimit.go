@@ -0,0 +1,141 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/pipeline"
+
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultPackTimeout is the default time to wait for a free pack
+const DefaultPackTimeout = 5 * time.Second
+
+// errNoPack is returned when no pack is freed up in time
+var errNoPack = errors.New("no free pack - the pipeline is saturated")
+
+// RateLimitConfig holds the token-bucket rate limits: the number of
+// requests allowed per second, and the size of the burst allowed above.
+// Zero rate means no limit.
+type RateLimitConfig struct {
+	PerIP         float64 `toml:"per_ip"`
+	PerIPBurst    int     `toml:"per_ip_burst"`
+	PerToken      float64 `toml:"per_token"`
+	PerTokenBurst int     `toml:"per_token_burst"`
+}
+
+// bucket is one token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds a token bucket for each key
+type rateLimiter struct {
+	rate, burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPurge time.Time
+}
+
+// newRateLimiter returns a rate limiter allowing rate requests per second,
+// with the given burst, or nil if rate is zero
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst),
+		buckets: make(map[string]*bucket, 16), lastPurge: time.Now()}
+}
+
+// allow takes a token from key's bucket. If there is none, returns false
+// and the time till the next token arrives.
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	if rl == nil {
+		return true, 0
+	}
+	now := time.Now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	// full buckets are the same as missing ones
+	if full := time.Duration(rl.burst / rl.rate * float64(time.Second)); now.Sub(rl.lastPurge) > full+time.Minute {
+		for k, b := range rl.buckets {
+			if now.Sub(b.last) > full {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastPurge = now
+	}
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rl.rate
+		if b.tokens > rl.burst {
+			b.tokens = rl.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// setRetryAfter sets the Retry-After header to d, rounded up to seconds
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	secs := int((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+// remoteIP returns the IP address of the client
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getPack returns a free pack, waiting at most packTimeout for it,
+// or until Run returns
+func (hsi *HTTPSimpleInput) getPack() (*pipeline.PipelinePack, error) {
+	select {
+	case pack := <-hsi.packs:
+		return pack, nil
+	default:
+	}
+	timer := time.NewTimer(hsi.packTimeout)
+	defer timer.Stop()
+	select {
+	case pack := <-hsi.packs:
+		return pack, nil
+	case <-hsi.done:
+		return nil, errStopping
+	case <-timer.C:
+		return nil, errNoPack
+	}
+}
@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/pipeline"
+
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(10, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.allow("a"); !ok {
+			t.Fatalf("%d. should be allowed by the burst", i)
+		}
+	}
+	ok, d := rl.allow("a")
+	if ok || d <= 0 || d > 100*time.Millisecond {
+		t.Errorf("third should be limited, got %t, %s", ok, d)
+	}
+	if ok, _ = rl.allow("b"); !ok {
+		t.Errorf("other keys should not be limited")
+	}
+	time.Sleep(d)
+	if ok, _ = rl.allow("a"); !ok {
+		t.Errorf("should be allowed after %s", d)
+	}
+	if ok, _ = (*rateLimiter)(nil).allow("a"); !ok {
+		t.Errorf("nil limiter should allow everything")
+	}
+}
+
+func TestHandlerLimits(t *testing.T) {
+	hsi, _ := newTestInput(1)
+	hsi.maxBodySize = 4
+	hsi.ipLimiter = newRateLimiter(1, 1)
+
+	r, _ := http.NewRequest("POST", "/", strings.NewReader("too long"))
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("too long: got %d", w.Code)
+	}
+
+	r, _ = http.NewRequest("POST", "/", strings.NewReader("ok"))
+	w = httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 201 {
+		t.Errorf("first: got %d", w.Code)
+	}
+	r, _ = http.NewRequest("POST", "/", strings.NewReader("ok"))
+	w = httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over the rate: got %d %q", w.Code, w.Header())
+	}
+
+	// the only pack is used up by the first request
+	hsi.ipLimiter, hsi.packTimeout = nil, 10*time.Millisecond
+	r, _ = http.NewRequest("POST", "/", strings.NewReader("ok"))
+	w = httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("without packs: got %d %q", w.Code, w.Header())
+	}
+}
+
+func TestSlowBody(t *testing.T) {
+	hsi, input := newTestInput(1)
+	hsi.packTimeout = 10 * time.Millisecond
+	td := testDecoder{in: make(chan *pipeline.PipelinePack, 1)}
+	hsi.DecoderRunner = func(name string) (pipeline.DecoderRunner, bool) { return td, true }
+
+	// a slow upload for the JSON decoder
+	pr, pw := io.Pipe()
+	r, _ := http.NewRequest("POST", "/", pr)
+	r.Header.Set("Content-Type", "application/json")
+	slow := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		slow <- w.Code
+	}()
+	pw.Write([]byte(`{"payload": `))
+
+	// does not hold the only pack
+	r, _ = http.NewRequest("POST", "/", strings.NewReader("ok"))
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 201 {
+		t.Errorf("while a body is uploaded: got %d: %s", w.Code, w.Body)
+	}
+	<-input
+
+	pw.Write([]byte(`"slow"}`))
+	pw.Close()
+	if code := <-slow; code != http.StatusServiceUnavailable {
+		t.Errorf("slow body without packs: got %d", code)
+	}
+}
@@ -0,0 +1,225 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRecentPath is the default path of the recent messages
+const DefaultRecentPath = "/recent"
+
+// RecentConfig holds the settings of the recent messages' ring buffers
+type RecentConfig struct {
+	// Size is the number of messages kept per route; zero disables
+	Size int `toml:"size"`
+	// Path is where they can be queried with GET (/recent by default)
+	Path string `toml:"path"`
+}
+
+// ringBuffer holds the last messages of a route
+type ringBuffer struct {
+	msgs []*message.Message
+	next int
+	full bool
+}
+
+// recentMessages holds a ring buffer for each route ("" is no route)
+type recentMessages struct {
+	size int
+
+	mu    sync.Mutex
+	rings map[string]*ringBuffer
+}
+
+// newRecentMessages returns the ring buffers of size, or nil if size is zero
+func newRecentMessages(size int) *recentMessages {
+	if size <= 0 {
+		return nil
+	}
+	return &recentMessages{size: size, rings: make(map[string]*ringBuffer)}
+}
+
+// add puts the message into the route's ring buffer
+func (rm *recentMessages) add(rt *route, msg *message.Message) {
+	key := rt.name()
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rb := rm.rings[key]
+	if rb == nil {
+		rb = &ringBuffer{msgs: make([]*message.Message, rm.size)}
+		rm.rings[key] = rb
+	}
+	rb.msgs[rb.next] = msg
+	if rb.next++; rb.next == rm.size {
+		rb.next, rb.full = 0, true
+	}
+}
+
+// recentFilter selects the recent messages
+type recentFilter struct {
+	route        *string
+	typ, logger  string
+	severity     *int32
+	since, until int64
+	limit        int
+}
+
+func (f recentFilter) match(msg *message.Message) bool {
+	return (f.typ == "" || msg.GetType() == f.typ) &&
+		(f.logger == "" || msg.GetLogger() == f.logger) &&
+		(f.severity == nil || msg.GetSeverity() <= *f.severity) &&
+		(f.since == 0 || msg.GetTimestamp() >= f.since) &&
+		(f.until == 0 || msg.GetTimestamp() < f.until)
+}
+
+// list returns the matching messages of the routes allowed, oldest first
+func (rm *recentMessages) list(f recentFilter, allowed func(key string) bool) []*message.Message {
+	var msgs []*message.Message
+	rm.mu.Lock()
+	for key, rb := range rm.rings {
+		if f.route != nil && *f.route != key || !allowed(key) {
+			continue
+		}
+		n := rb.next
+		if rb.full {
+			n = rm.size
+		}
+		for i := 0; i < n; i++ {
+			if msg := rb.msgs[i]; f.match(msg) {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+	rm.mu.Unlock()
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].GetTimestamp() < msgs[j].GetTimestamp() })
+	if f.limit > 0 && len(msgs) > f.limit {
+		msgs = msgs[len(msgs)-f.limit:]
+	}
+	return msgs
+}
+
+// recentMessage is the JSON form of a message
+type recentMessage struct {
+	UUID      string                 `json:"uuid"`
+	Timestamp time.Time              `json:"timestamp"`
+	Type      string                 `json:"type,omitempty"`
+	Logger    string                 `json:"logger,omitempty"`
+	Severity  int32                  `json:"severity"`
+	Hostname  string                 `json:"hostname,omitempty"`
+	Payload   string                 `json:"payload,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+func newRecentMessage(msg *message.Message) recentMessage {
+	rm := recentMessage{UUID: msg.GetUuidString(),
+		Timestamp: time.Unix(0, msg.GetTimestamp()).UTC(),
+		Type:      msg.GetType(), Logger: msg.GetLogger(), Severity: msg.GetSeverity(),
+		Hostname: msg.GetHostname(), Payload: msg.GetPayload(),
+	}
+	if len(msg.Fields) > 0 {
+		rm.Fields = make(map[string]interface{}, len(msg.Fields))
+		for _, f := range msg.Fields {
+			var vs interface{}
+			switch f.GetValueType() {
+			case message.Field_STRING:
+				vs = f.GetValueString()
+			case message.Field_BYTES:
+				vs = f.GetValueBytes()
+			case message.Field_INTEGER:
+				vs = f.GetValueInteger()
+			case message.Field_DOUBLE:
+				vs = f.GetValueDouble()
+			case message.Field_BOOL:
+				vs = f.GetValueBool()
+			}
+			rm.Fields[f.GetName()] = vs
+		}
+	}
+	return rm
+}
+
+// parseRecentFilter parses the query of a recent messages' request:
+// route, type, logger, severity (at most), since, until and limit
+func (hsi *HTTPSimpleInput) parseRecentFilter(r *http.Request) (recentFilter, error) {
+	var f recentFilter
+	q := r.URL.Query()
+	if _, ok := q["route"]; ok {
+		rt := q.Get("route")
+		f.route = &rt
+	}
+	f.typ, f.logger = q.Get("type"), q.Get("logger")
+	if s := q.Get("severity"); s != "" {
+		i, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return f, fmt.Errorf("bad severity %q: %s", s, err)
+		}
+		sev := int32(i)
+		f.severity = &sev
+	}
+	for _, t := range []struct {
+		name string
+		dest *int64
+	}{{"since", &f.since}, {"until", &f.until}} {
+		if s := q.Get(t.name); s != "" {
+			ts, err := parseTimestamp(s, hsi.timestampUnit)
+			if err != nil {
+				return f, fmt.Errorf("bad %s %q: %s", t.name, s, err)
+			}
+			*t.dest = ts
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		var err error
+		if f.limit, err = strconv.Atoi(s); err != nil {
+			return f, fmt.Errorf("bad limit %q: %s", s, err)
+		}
+	}
+	return f, nil
+}
+
+// serveRecent answers the query of the recent messages of the routes
+// the identity is allowed on
+func (hsi *HTTPSimpleInput) serveRecent(w http.ResponseWriter, req request) {
+	f, err := hsi.parseRecentFilter(req.Request)
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "%s\n", err)
+		return
+	}
+	msgs := hsi.recent.list(f, func(key string) bool {
+		if key == "" {
+			return true
+		}
+		for _, rt := range hsi.routes {
+			if rt.name() == key {
+				return rt.allows(req.id)
+			}
+		}
+		return false
+	})
+	res := make([]recentMessage, len(msgs))
+	for i, msg := range msgs {
+		res[i] = newRecentMessage(msg)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(res)
+}
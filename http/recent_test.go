@@ -0,0 +1,88 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecent(t *testing.T) {
+	hsi, _ := newTestInput(8)
+	hsi.recent, hsi.recentPath = newRecentMessages(2), DefaultRecentPath
+	var err error
+	if hsi.routes, err = newRoutes([]RouteConfig{{Path: "/secret", Tokens: []string{"admin"}}}); err != nil {
+		t.Fatal(err)
+	}
+	hsi.auths = []authenticator{tokenAuth{{Token: "a", Name: "admin"}, {Token: "u", Name: "user"}}}
+
+	do := func(method, url, token string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, url, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w
+	}
+	for i, url := range []string{
+		"/?payload=1&type=a&timestamp=1000",
+		"/?payload=2&type=b&severity=3&timestamp=2000",
+		"/?payload=3&type=a&timestamp=3000",
+		"/secret?payload=4&timestamp=4000",
+	} {
+		token := "u"
+		if i == 3 {
+			token = "a"
+		}
+		if w := do("POST", url, token); w.Code != 201 {
+			t.Fatalf("%s: got %d: %s", url, w.Code, w.Body)
+		}
+	}
+
+	get := func(url, token string) []recentMessage {
+		w := do("GET", url, token)
+		if w.Code != 200 {
+			t.Fatalf("%s: got %d: %s", url, w.Code, w.Body)
+		}
+		var res []recentMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: %s", url, err)
+		}
+		return res
+	}
+	payloads := func(res []recentMessage) string {
+		var s string
+		for _, m := range res {
+			s += m.Payload
+		}
+		return s
+	}
+	for _, tc := range []struct {
+		url, token, want string
+	}{
+		{"/recent", "u", "23"}, // the ring holds 2, /secret is not allowed
+		{"/recent", "a", "234"},
+		{"/recent?type=a", "a", "3"},
+		{"/recent?severity=4", "a", "2"},
+		{"/recent?since=2500&until=4000", "a", "3"},
+		{"/recent?route=/secret", "a", "4"},
+		{"/recent?limit=1", "a", "4"},
+	} {
+		if got := payloads(get(tc.url, tc.token)); got != tc.want {
+			t.Errorf("%s as %s: got %q, wanted %q", tc.url, tc.token, got, tc.want)
+		}
+	}
+	if w := do("GET", "/recent", "bad"); w.Code != 403 && w.Code != 401 {
+		t.Errorf("bad token: got %d", w.Code)
+	}
+}
@@ -0,0 +1,140 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RouteConfig holds the message defaults for the requests of a path.
+// The path is matched exactly, or as a prefix if it ends with "*".
+type RouteConfig struct {
+	Path     string `toml:"path"`
+	Type     string `toml:"type"`
+	Logger   string `toml:"logger"`
+	Severity *int32 `toml:"severity"`
+	// Fields are added to the messages which don't have them
+	Fields map[string]interface{} `toml:"fields"`
+	// Tokens are the names of the tokens allowed on this route;
+	// other identities can be given as "basic:user" or "hmac:key".
+	// If empty, any (or no) identity is allowed.
+	Tokens []string `toml:"tokens"`
+}
+
+// route is the parsed RouteConfig
+type route struct {
+	RouteConfig
+	prefix  bool
+	fields  []*message.Field
+	allowed map[string]bool
+}
+
+// request holds the per-request data shared by all of its messages
+type request struct {
+	*http.Request
+	id       *identity
+	route    *route
+	webhook  *webhook
+	clientIP string
+}
+
+// newRoutes checks the route configs and returns the routes
+func newRoutes(rcs []RouteConfig) ([]*route, error) {
+	routes := make([]*route, 0, len(rcs))
+	for _, rc := range rcs {
+		if rc.Path == "" || rc.Path[0] != '/' {
+			return nil, fmt.Errorf("route path %q must start with /", rc.Path)
+		}
+		rt := &route{RouteConfig: rc}
+		if strings.HasSuffix(rc.Path, "*") {
+			rt.Path, rt.prefix = rc.Path[:len(rc.Path)-1], true
+		}
+		names := make([]string, 0, len(rc.Fields))
+		for k := range rc.Fields {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			f, err := newField(k, "", []interface{}{rc.Fields[k]})
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %s", rc.Path, err)
+			}
+			rt.fields = append(rt.fields, f)
+		}
+		if len(rc.Tokens) > 0 {
+			rt.allowed = make(map[string]bool, len(rc.Tokens))
+			for _, name := range rc.Tokens {
+				if strings.IndexByte(name, ':') < 0 {
+					name = "token:" + name
+				}
+				rt.allowed[name] = true
+			}
+		}
+		routes = append(routes, rt)
+	}
+	return routes, nil
+}
+
+// matchRoute returns the first route matching the path, or nil
+func (hsi *HTTPSimpleInput) matchRoute(path string) *route {
+	for _, rt := range hsi.routes {
+		if rt.Path == path || rt.prefix && strings.HasPrefix(path, rt.Path) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// name returns the route's path as configured, or "" for no route
+func (rt *route) name() string {
+	if rt == nil {
+		return ""
+	}
+	if rt.prefix {
+		return rt.Path + "*"
+	}
+	return rt.Path
+}
+
+// allows reports whether the identity may use the route
+func (rt *route) allows(id *identity) bool {
+	if rt == nil || rt.allowed == nil {
+		return true
+	}
+	return id != nil && rt.allowed[id.Name]
+}
+
+// applyDefaults sets the route's defaults on the message, where missing
+func (rt *route) applyDefaults(msg *message.Message) {
+	if rt == nil {
+		return
+	}
+	if msg.Type == nil && rt.Type != "" {
+		msg.SetType(rt.Type)
+	}
+	if msg.Logger == nil && rt.Logger != "" {
+		msg.SetLogger(rt.Logger)
+	}
+	if msg.Severity == nil && rt.Severity != nil {
+		msg.SetSeverity(*rt.Severity)
+	}
+	for _, f := range rt.fields {
+		if msg.FindFirstField(f.GetName()) == nil {
+			msg.AddField(message.CopyField(f))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	hsi, input := newTestInput(2)
+	sev := int32(3)
+	var err error
+	hsi.routes, err = newRoutes([]RouteConfig{
+		{Path: "/app/billing", Type: "billing", Logger: "billing", Severity: &sev,
+			Fields: map[string]interface{}{"team": "money", "prio": int64(2)},
+			Tokens: []string{"billing"}},
+		{Path: "/cron/*", Type: "cron"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsi.auths = []authenticator{tokenAuth{
+		{Token: "b", Name: "billing"}, {Token: "o", Name: "other"}}}
+
+	post := func(path, token string) int {
+		r, _ := http.NewRequest("POST", path, strings.NewReader("x"))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w.Code
+	}
+	if code := post("/app/billing", "o"); code != http.StatusForbidden {
+		t.Errorf("other token on billing: got %d", code)
+	}
+	if code := post("/app/billing?logger=mine", "b"); code != 201 {
+		t.Fatalf("billing: got %d", code)
+	}
+	m := (<-input).Message
+	if m.GetType() != "billing" || m.GetLogger() != "mine" || m.GetSeverity() != 3 {
+		t.Errorf("billing defaults are not applied: %s", m)
+	}
+	if f := m.FindFirstField("prio"); f == nil || f.GetValue() != int64(2) {
+		t.Errorf("billing fields are not applied: %s", m)
+	}
+	if code := post("/cron/daily", "o"); code != 201 {
+		t.Fatalf("cron: got %d", code)
+	}
+	if m = (<-input).Message; m.GetType() != "cron" {
+		t.Errorf("cron: got type %q", m.GetType())
+	}
+}
@@ -0,0 +1,237 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// InvalidField is the field listing the violations in tag mode
+const InvalidField = "invalid"
+
+// SchemaConfig constrains the messages of a type
+type SchemaConfig struct {
+	Type string `toml:"type"`
+	// Required are the names of the fields that must be present
+	Required []string `toml:"required"`
+	// Fields are the value types of the fields: string, bytes, integer,
+	// double (integers are accepted, too) or bool
+	Fields map[string]string `toml:"fields"`
+	// Severity is the allowed [min, max] range
+	Severity []int32 `toml:"severity"`
+	// MaxPayloadLength limits the length of the payload
+	MaxPayloadLength int `toml:"max_payload_length"`
+	// Patterns are the regexps the values of the fields must match;
+	// "Payload", "Hostname" and "Logger" stand for the message's own
+	Patterns map[string]string `toml:"patterns"`
+}
+
+// schema is a checked SchemaConfig
+type schema struct {
+	required         []string
+	fields           map[string]message.Field_ValueType
+	minSev, maxSev   int32
+	maxPayloadLength int
+	patterns         map[string]*regexp.Regexp
+	names            []string // the sorted names of the patterns
+}
+
+// schemaError lists the violations of the schema of the message's type
+type schemaError struct {
+	Type       string   `json:"type"`
+	Violations []string `json:"violations"`
+}
+
+func (se schemaError) Error() string {
+	return fmt.Sprintf("invalid %q message: %s", se.Type, strings.Join(se.Violations, "; "))
+}
+
+// newSchemas checks the schema configs, and returns the schemas by type
+func newSchemas(scs []SchemaConfig) (map[string]*schema, error) {
+	if len(scs) == 0 {
+		return nil, nil
+	}
+	schemas := make(map[string]*schema, len(scs))
+	for _, sc := range scs {
+		if _, ok := schemas[sc.Type]; ok {
+			return nil, fmt.Errorf("duplicate schema for type %q", sc.Type)
+		}
+		s := &schema{required: sc.Required, minSev: 0, maxSev: 7,
+			maxPayloadLength: sc.MaxPayloadLength,
+			fields:           make(map[string]message.Field_ValueType, len(sc.Fields)),
+			patterns:         make(map[string]*regexp.Regexp, len(sc.Patterns))}
+		for name, typ := range sc.Fields {
+			vt, ok := message.Field_ValueType_value[strings.ToUpper(typ)]
+			if !ok {
+				return nil, fmt.Errorf("schema %q: unknown type %q of field %s", sc.Type, typ, name)
+			}
+			s.fields[name] = message.Field_ValueType(vt)
+		}
+		switch len(sc.Severity) {
+		case 0:
+		case 2:
+			s.minSev, s.maxSev = sc.Severity[0], sc.Severity[1]
+			if s.minSev < 0 || s.minSev > s.maxSev || s.maxSev > 7 {
+				return nil, fmt.Errorf("schema %q: severity %v is not a range in [0, 7]", sc.Type, sc.Severity)
+			}
+		default:
+			return nil, fmt.Errorf("schema %q: severity must be [min, max]", sc.Type)
+		}
+		for name, pat := range sc.Patterns {
+			rx, err := regexp.Compile(pat)
+			if err != nil {
+				return nil, fmt.Errorf("schema %q: bad pattern of %s: %s", sc.Type, name, err)
+			}
+			s.patterns[name] = rx
+			s.names = append(s.names, name)
+		}
+		sort.Strings(s.names)
+		schemas[sc.Type] = s
+	}
+	return schemas, nil
+}
+
+// check returns the violations of the message
+func (s *schema) check(msg *message.Message) []string {
+	var violations []string
+	for _, name := range s.required {
+		if msg.FindFirstField(name) == nil {
+			violations = append(violations, fmt.Sprintf("%s is required", name))
+		}
+	}
+	for _, f := range msg.Fields {
+		vt, ok := s.fields[f.GetName()]
+		if !ok || f.GetValueType() == vt ||
+			vt == message.Field_DOUBLE && f.GetValueType() == message.Field_INTEGER {
+			continue
+		}
+		violations = append(violations, fmt.Sprintf("%s must be %s, not %s",
+			f.GetName(), strings.ToLower(vt.String()), strings.ToLower(f.GetValueType().String())))
+	}
+	if sev := msg.GetSeverity(); sev < s.minSev || sev > s.maxSev {
+		violations = append(violations, fmt.Sprintf("severity %d is not in [%d, %d]", sev, s.minSev, s.maxSev))
+	}
+	if s.maxPayloadLength > 0 && len(msg.GetPayload()) > s.maxPayloadLength {
+		violations = append(violations, fmt.Sprintf("payload is longer than %d", s.maxPayloadLength))
+	}
+	for _, name := range s.names {
+		var values []string
+		switch name {
+		case "Payload":
+			values = []string{msg.GetPayload()}
+		case "Hostname":
+			values = []string{msg.GetHostname()}
+		case "Logger":
+			values = []string{msg.GetLogger()}
+		default:
+			f := msg.FindFirstField(name)
+			if f == nil {
+				continue
+			}
+			for _, v := range fieldValues(f) {
+				values = append(values, fmt.Sprint(v))
+			}
+		}
+		for _, v := range values {
+			if !s.patterns[name].MatchString(v) {
+				violations = append(violations, fmt.Sprintf("%s does not match %s", name, s.patterns[name]))
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// fieldValues returns the values of the field
+func fieldValues(f *message.Field) []interface{} {
+	var vs []interface{}
+	switch f.GetValueType() {
+	case message.Field_STRING:
+		for _, v := range f.GetValueString() {
+			vs = append(vs, v)
+		}
+	case message.Field_BYTES:
+		for _, v := range f.GetValueBytes() {
+			vs = append(vs, string(v))
+		}
+	case message.Field_INTEGER:
+		for _, v := range f.GetValueInteger() {
+			vs = append(vs, v)
+		}
+	case message.Field_DOUBLE:
+		for _, v := range f.GetValueDouble() {
+			vs = append(vs, v)
+		}
+	case message.Field_BOOL:
+		for _, v := range f.GetValueBool() {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// checkSchema checks the message against the schema of its type.
+// In tag mode, the violations are listed in the "invalid" field, and the
+// message passes; else the returned error is a schemaError.
+func (hsi *HTTPSimpleInput) checkSchema(msg *message.Message) error {
+	s := hsi.schemas[msg.GetType()]
+	if s == nil {
+		return nil
+	}
+	violations := s.check(msg)
+	if len(violations) == 0 {
+		return nil
+	}
+	atomic.AddInt64(&hsi.stats.Invalid, 1)
+	if hsi.tagInvalid {
+		// a string field cannot fail
+		f, _ := message.NewField(InvalidField, violations[0], "")
+		for _, v := range violations[1:] {
+			f.AddValue(v)
+		}
+		msg.AddField(f)
+		return nil
+	}
+	return schemaError{Type: msg.GetType(), Violations: violations}
+}
+
+// checkMessage checks the timestamp against max_timestamp_skew,
+// then the message against the schema of its type
+func (hsi *HTTPSimpleInput) checkMessage(msg *message.Message) error {
+	if hsi.maxTimestampSkew > 0 && msg.Timestamp != nil {
+		ts := msg.GetTimestamp()
+		if d := time.Duration(time.Now().UnixNano() - ts); d > hsi.maxTimestampSkew || d < -hsi.maxTimestampSkew {
+			atomic.AddInt64(&hsi.stats.Invalid, 1)
+			return schemaError{Type: msg.GetType(), Violations: []string{fmt.Sprintf(
+				"timestamp %s is out of the accepted window (%s)",
+				time.Unix(0, ts).UTC().Format(time.RFC3339Nano), hsi.maxTimestampSkew)}}
+		}
+	}
+	return hsi.checkSchema(msg)
+}
+
+// writeInvalid answers 422 with the violations
+func (hsi *HTTPSimpleInput) writeInvalid(w http.ResponseWriter, se schemaError) {
+	atomic.AddInt64(&hsi.stats.Rejected, 1)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(se)
+}
@@ -0,0 +1,120 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	hsi, input := newTestInput(4)
+	hsi.flatJSON = true
+	var err error
+	if hsi.schemas, err = newSchemas([]SchemaConfig{{
+		Type:             "order",
+		Required:         []string{"order_id"},
+		Fields:           map[string]string{"order_id": "string", "amount": "double"},
+		Severity:         []int32{3, 6},
+		MaxPayloadLength: 10,
+		Patterns:         map[string]string{"order_id": `^o-\d+$`, "Logger": `^shop`},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w
+	}
+
+	if w := post(`{"type": "order", "logger": "shop.api", "severity": 5, "order_id": "o-1", "amount": 3, "payload": "paid"}`); w.Code != 201 {
+		t.Fatalf("valid: got %d: %s", w.Code, w.Body)
+	}
+	<-input
+	// other types are not checked
+	if w := post(`{"type": "other", "severity": 7}`); w.Code != 201 {
+		t.Fatalf("other: got %d: %s", w.Code, w.Body)
+	}
+	<-input
+
+	w := post(`{"type": "order", "logger": "cart", "severity": 7, "amount": "3", "payload": "far too long"}`)
+	if w.Code != 422 {
+		t.Fatalf("invalid: got %d: %s", w.Code, w.Body)
+	}
+	var se schemaError
+	if err = json.Unmarshal(w.Body.Bytes(), &se); err != nil {
+		t.Fatalf("cannot parse response %q: %s", w.Body, err)
+	}
+	want := []string{"order_id is required", "amount must be double, not string",
+		"severity 7 is not in [3, 6]", "payload is longer than 10", "Logger does not match ^shop"}
+	if se.Type != "order" || strings.Join(se.Violations, "|") != strings.Join(want, "|") {
+		t.Errorf("invalid: got %+v, wanted %q", se, want)
+	}
+	if s := hsi.stats.snapshot(); s.Invalid != 1 || s.Rejected != 1 {
+		t.Errorf("got %+v, wanted 1 invalid and rejected", s)
+	}
+
+	// tag mode lets it through
+	hsi.tagInvalid = true
+	if w = post(`{"type": "order", "logger": "shop", "severity": 4, "order_id": "x", "payload": "-"}`); w.Code != 201 {
+		t.Fatalf("tag: got %d: %s", w.Code, w.Body)
+	}
+	m := (<-input).Message
+	if f := m.FindFirstField(InvalidField); f == nil || f.GetValue() != "order_id does not match ^o-\\d+$" {
+		t.Errorf("tag: field %s is %v", InvalidField, f)
+	}
+
+	for _, sc := range []SchemaConfig{
+		{Type: "a", Fields: map[string]string{"x": "float"}},
+		{Type: "a", Severity: []int32{1}},
+		{Type: "a", Severity: []int32{6, 3}},
+		{Type: "a", Severity: []int32{-1, 3}},
+		{Type: "a", Severity: []int32{3, 8}},
+		{Type: "a", Patterns: map[string]string{"x": "("}},
+	} {
+		if _, err = newSchemas([]SchemaConfig{sc}); err == nil {
+			t.Errorf("%+v: no error", sc)
+		}
+	}
+}
+
+func TestSchemaBatch(t *testing.T) {
+	hsi, input := newTestInput(4)
+	hsi.batchDelim = []byte{'\n'}
+	hsi.schemas, _ = newSchemas([]SchemaConfig{{Type: "line", MaxPayloadLength: 5}})
+
+	r, _ := http.NewRequest("POST", "/?type=line", strings.NewReader("short\ntoo long\nok\n"))
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	var res batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("cannot parse response %q: %s", w.Body, err)
+	}
+	if w.Code != 201 || res.Accepted != 2 || len(res.Rejected) != 1 || res.Rejected[0] != 2 {
+		t.Errorf("got %d %+v", w.Code, res)
+	}
+	<-input
+	<-input
+
+	r, _ = http.NewRequest("POST", "/?type=line", strings.NewReader("too long\n"))
+	w = httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 422 {
+		t.Errorf("all invalid: got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Default server timeouts
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultIdleTimeout       = 2 * time.Minute
+)
+
+// ServerConfig holds the HTTP server's connection settings
+type ServerConfig struct {
+	// ReadTimeout limits reading the whole request (none by default)
+	ReadTimeout string `toml:"read_timeout"`
+	// ReadHeaderTimeout limits reading the request headers (10s by default)
+	ReadHeaderTimeout string `toml:"read_header_timeout"`
+	// WriteTimeout limits the whole request till the response is written
+	// (none by default)
+	WriteTimeout string `toml:"write_timeout"`
+	// IdleTimeout limits the wait for the next request on a keep-alive
+	// connection (2m by default)
+	IdleTimeout string `toml:"idle_timeout"`
+	// DisableKeepAlives closes the connection after each request
+	DisableKeepAlives bool `toml:"disable_keep_alives"`
+	// MaxHeaderBytes limits the size of the request headers (1MiB by default)
+	MaxHeaderBytes int `toml:"max_header_bytes"`
+	// MaxConns limits the number of concurrent connections
+	MaxConns int `toml:"max_conns"`
+	// H2C allows cleartext HTTP/2 (with prior knowledge)
+	H2C bool `toml:"h2c"`
+	// MaxStreams limits the concurrent streams of an HTTP/2 connection
+	MaxStreams int `toml:"max_streams"`
+}
+
+// serverSettings is the parsed ServerConfig
+type serverSettings struct {
+	ServerConfig
+	readTimeout, readHeaderTimeout, writeTimeout, idleTimeout time.Duration
+}
+
+// newServerSettings parses the durations of the config
+func newServerSettings(sc ServerConfig) (serverSettings, error) {
+	ss := serverSettings{ServerConfig: sc,
+		readHeaderTimeout: DefaultReadHeaderTimeout, idleTimeout: DefaultIdleTimeout}
+	for _, d := range []struct {
+		name, value string
+		dest        *time.Duration
+	}{
+		{"read_timeout", sc.ReadTimeout, &ss.readTimeout},
+		{"read_header_timeout", sc.ReadHeaderTimeout, &ss.readHeaderTimeout},
+		{"write_timeout", sc.WriteTimeout, &ss.writeTimeout},
+		{"idle_timeout", sc.IdleTimeout, &ss.idleTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		var err error
+		if *d.dest, err = time.ParseDuration(d.value); err != nil {
+			return ss, fmt.Errorf("error parsing server %s %q: %s", d.name, d.value, err)
+		}
+	}
+	if sc.MaxConns < 0 || sc.MaxStreams < 0 || sc.MaxHeaderBytes < 0 {
+		return ss, fmt.Errorf("server limits must not be negative")
+	}
+	return ss, nil
+}
+
+// newServer returns the HTTP server with the settings applied.
+// If HTTP/2 cannot be used with the TLS settings, it serves HTTP/1.1 only.
+func (hsi *HTTPSimpleInput) newServer(logError func(error)) *http.Server {
+	ss := hsi.serverSettings
+	var handler http.Handler = http.HandlerFunc(hsi.handler)
+	srv := &http.Server{Addr: hsi.Address,
+		ReadTimeout: ss.readTimeout, ReadHeaderTimeout: ss.readHeaderTimeout,
+		WriteTimeout: ss.writeTimeout, IdleTimeout: ss.idleTimeout,
+		MaxHeaderBytes: ss.MaxHeaderBytes,
+		TLSConfig:      hsi.tlsConfig,
+	}
+	srv.SetKeepAlivesEnabled(!ss.DisableKeepAlives)
+	h2s := &http2.Server{MaxConcurrentStreams: uint32(ss.MaxStreams), IdleTimeout: ss.idleTimeout}
+	if hsi.tlsConfig != nil {
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			// e.g. the ciphers miss the ones HTTP/2 requires
+			logError(fmt.Errorf("serving HTTP/1.1 only: %s", err))
+			srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+	} else if ss.H2C {
+		// registers h2s to send GOAWAY on Shutdown, which does not know
+		// about the hijacked h2c connections
+		http2.ConfigureServer(srv, h2s)
+		srv.TLSConfig = nil
+		handler = h2c.NewHandler(handler, h2s)
+	}
+	srv.Handler = handler
+	return srv
+}
@@ -0,0 +1,128 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"golang.org/x/net/http2"
+
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerSettings(t *testing.T) {
+	ss, err := newServerSettings(ServerConfig{ReadTimeout: "30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.readTimeout != 30*time.Second || ss.readHeaderTimeout != DefaultReadHeaderTimeout ||
+		ss.idleTimeout != DefaultIdleTimeout || ss.writeTimeout != 0 {
+		t.Errorf("got %+v", ss)
+	}
+	if _, err = newServerSettings(ServerConfig{IdleTimeout: "soon"}); err == nil {
+		t.Error("bad duration should fail")
+	}
+}
+
+func TestHTTP2Fallback(t *testing.T) {
+	cert := newTestCert(t, "server", nil)
+	// HTTP/2 needs TLS_ECDHE_*_WITH_AES_128_GCM_SHA256
+	hsi := &HTTPSimpleInput{drainTimeout: time.Second, packTimeout: time.Second,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert.tlsCert()}, MinVersion: tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}}}
+	tr := newTestRunner(1)
+	addr, runErr := startRun(t, hsi, tr)
+	select {
+	case err := <-tr.errs:
+		if !strings.Contains(err.Error(), "HTTP/1.1 only") {
+			t.Errorf("got %s", err)
+		}
+	default:
+		t.Error("the fallback is not logged")
+	}
+
+	client := &http.Client{Transport: &http.Transport{ForceAttemptHTTP2: true,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Post("https://"+addr+"/", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 201 || resp.ProtoMajor != 1 {
+		t.Errorf("got %d over %s", resp.StatusCode, resp.Proto)
+	}
+	hsi.Stop()
+	if err = <-runErr; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestH2CDrain(t *testing.T) {
+	hsi := &HTTPSimpleInput{drainTimeout: 5 * time.Second, packTimeout: time.Second,
+		serverSettings: serverSettings{ServerConfig: ServerConfig{H2C: true}}}
+	tr := newTestRunner(1)
+	addr, runErr := startRun(t, hsi, tr)
+
+	client := &http.Client{Transport: &http2.Transport{AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		}}}
+	pr, pw := io.Pipe()
+	r, _ := http.NewRequest("POST", "http://"+addr+"/", pr)
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Error(err)
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 {
+			t.Errorf("got %s", resp.Proto)
+		}
+		codes <- resp.StatusCode
+	}()
+	pw.Write([]byte("hel"))
+	waitFor(t, "the body", func() bool { return atomic.LoadInt64(&hsi.stats.BytesIn) > 0 })
+
+	// Shutdown does not track the hijacked h2c connection
+	hsi.Stop()
+	waitFor(t, "draining", func() bool { return atomic.LoadInt32(&hsi.draining) == 1 })
+	select {
+	case err := <-runErr:
+		t.Fatalf("Run returned before the in-flight request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pw.Write([]byte("lo"))
+	pw.Close()
+	if code := <-codes; code != 201 {
+		t.Errorf("in-flight: got %d, wanted 201", code)
+	}
+	if m := (<-tr.injected).Message; m.GetPayload() != "hello" {
+		t.Errorf("in-flight: got %s", m)
+	}
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run has not returned")
+	}
+}
@@ -0,0 +1,114 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// stats holds the counters of the input, all used atomically
+type stats struct {
+	Accepted     int64 `json:"accepted"`      // messages injected
+	Rejected     int64 `json:"rejected"`      // requests and batch lines rejected
+	DecodeFailed int64 `json:"decode_failed"` // unparseable requests and lines
+	BytesIn      int64 `json:"bytes_in"`      // raw body bytes read
+	AuthRejected int64 `json:"auth_rejected"` // requests rejected by authentication
+	RateLimited  int64 `json:"rate_limited"`  // requests rejected by the rate limits
+	PackTimeouts int64 `json:"pack_timeouts"` // requests rejected for lack of free packs
+	Duplicates   int64 `json:"duplicates"`    // replayed requests not injected again
+	Invalid      int64 `json:"invalid"`       // messages violating their type's schema
+}
+
+// snapshot returns a consistent copy of the counters
+func (s *stats) snapshot() stats {
+	return stats{
+		Accepted:     atomic.LoadInt64(&s.Accepted),
+		Rejected:     atomic.LoadInt64(&s.Rejected),
+		DecodeFailed: atomic.LoadInt64(&s.DecodeFailed),
+		BytesIn:      atomic.LoadInt64(&s.BytesIn),
+		AuthRejected: atomic.LoadInt64(&s.AuthRejected),
+		RateLimited:  atomic.LoadInt64(&s.RateLimited),
+		PackTimeouts: atomic.LoadInt64(&s.PackTimeouts),
+		Duplicates:   atomic.LoadInt64(&s.Duplicates),
+		Invalid:      atomic.LoadInt64(&s.Invalid),
+	}
+}
+
+// countingReader counts the bytes read into the BytesIn counter
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	atomic.AddInt64(cr.n, int64(n))
+	return n, err
+}
+
+// ReportMsg adds the counters to the report message, for the dashboard
+func (hsi *HTTPSimpleInput) ReportMsg(msg *message.Message) error {
+	s := hsi.stats.snapshot()
+	message.NewInt64Field(msg, "Accepted", s.Accepted, "count")
+	message.NewInt64Field(msg, "Rejected", s.Rejected, "count")
+	message.NewInt64Field(msg, "DecodeFailed", s.DecodeFailed, "count")
+	message.NewInt64Field(msg, "BytesIn", s.BytesIn, "B")
+	message.NewInt64Field(msg, "AuthRejected", s.AuthRejected, "count")
+	message.NewInt64Field(msg, "RateLimited", s.RateLimited, "count")
+	message.NewInt64Field(msg, "PackTimeouts", s.PackTimeouts, "count")
+	message.NewInt64Field(msg, "Duplicates", s.Duplicates, "count")
+	message.NewInt64Field(msg, "Invalid", s.Invalid, "count")
+	return nil
+}
+
+// isReady reports whether the listener is up and there are free packs
+func (hsi *HTTPSimpleInput) isReady() bool {
+	packs := hsi.packs
+	return atomic.LoadInt32(&hsi.listening) == 1 && packs != nil && len(packs) > 0
+}
+
+// serveStatus answers the GET requests for the health, readiness and
+// stats paths, and reports whether the request was such.
+func (hsi *HTTPSimpleInput) serveStatus(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	switch r.URL.Path {
+	case "":
+		return false
+	case hsi.healthPath:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(200)
+		io.WriteString(w, "OK\n")
+	case hsi.readyPath:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !hsi.isReady() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "not ready\n")
+			return true
+		}
+		w.WriteHeader(200)
+		io.WriteString(w, "ready\n")
+	case hsi.statsPath:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(hsi.stats.snapshot())
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	hsi, _ := newTestInput(2)
+	conf := hsi.ConfigStruct().(*HTTPSimpleInputConfig)
+	hsi.healthPath, hsi.readyPath, hsi.statsPath = conf.HealthPath, conf.ReadyPath, conf.StatsPath
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w
+	}
+	if w := get("/healthz"); w.Code != 200 {
+		t.Errorf("healthz: got %d", w.Code)
+	}
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz without listener: got %d", w.Code)
+	}
+	hsi.listening = 1
+	if w := get("/readyz"); w.Code != 200 {
+		t.Errorf("readyz: got %d", w.Code)
+	}
+
+	r, _ := http.NewRequest("POST", "/?severity=x", strings.NewReader("abc"))
+	hsi.handler(httptest.NewRecorder(), r)
+	r, _ = http.NewRequest("POST", "/", strings.NewReader("abc"))
+	hsi.handler(httptest.NewRecorder(), r)
+
+	w := get("/stats")
+	var s stats
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("cannot parse %q: %s", w.Body, err)
+	}
+	if s.Accepted != 1 || s.Rejected != 1 || s.DecodeFailed != 1 || s.BytesIn != 3 {
+		t.Errorf("got %+v", s)
+	}
+	if w := get("/other"); w.Code != 400 {
+		t.Errorf("other GET: got %d", w.Code)
+	}
+}
+
+func TestDraining(t *testing.T) {
+	hsi, _ := newTestInput(2)
+	post := func() int {
+		r, _ := http.NewRequest("POST", "/?payload=x", nil)
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w.Code
+	}
+
+	hsi.draining = 1
+	if code := post(); code != 503 {
+		t.Errorf("draining: got %d, wanted 503", code)
+	}
+
+	// Run has returned: nobody takes the pack, it must be recycled
+	hsi.draining = 0
+	hsi.input = make(chan *pipeline.PipelinePack)
+	hsi.done = make(chan struct{})
+	close(hsi.done)
+	if code := post(); code != 503 {
+		t.Errorf("stopped: got %d, wanted 503", code)
+	}
+}
+
+// testRunner is an InputRunner collecting the injected packs
+type testRunner struct {
+	pipeline.InputRunner
+	packs    chan *pipeline.PipelinePack
+	injected chan *pipeline.PipelinePack
+	errs     chan error
+}
+
+func newTestRunner(n int) testRunner {
+	tr := testRunner{packs: make(chan *pipeline.PipelinePack, n),
+		injected: make(chan *pipeline.PipelinePack, n), errs: make(chan error, 1)}
+	for i := 0; i < n; i++ {
+		tr.packs <- &pipeline.PipelinePack{Message: new(message.Message)}
+	}
+	return tr
+}
+
+func (tr testRunner) InChan() chan *pipeline.PipelinePack     { return tr.packs }
+func (tr testRunner) Inject(pack *pipeline.PipelinePack) bool { tr.injected <- pack; return true }
+func (tr testRunner) LogError(err error)                      { tr.errs <- err }
+
+// testHelper is a PluginHelper without decoders
+type testHelper struct {
+	pipeline.PluginHelper
+}
+
+func (testHelper) DecoderRunner(name string) (pipeline.DecoderRunner, bool) { return nil, false }
+
+// startRun runs hsi on a free port, and returns its address and Run's result
+func startRun(t *testing.T, hsi *HTTPSimpleInput, tr testRunner) (string, chan error) {
+	hsi.Address = "127.0.0.1:0"
+	runErr := make(chan error, 1)
+	go func() { runErr <- hsi.Run(tr, testHelper{}) }()
+	waitFor(t, "listening", func() bool { return atomic.LoadInt32(&hsi.listening) == 1 })
+	return hsi.listener.Addr().String(), runErr
+}
+
+// waitFor waits at most 5s for cond
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	hsi := &HTTPSimpleInput{drainTimeout: 5 * time.Second, packTimeout: time.Second}
+	tr := newTestRunner(2)
+	addr, runErr := startRun(t, hsi, tr)
+
+	// a request whose headers are not finished yet - accepted before the
+	// in-flight one
+	late, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	late.Write([]byte("POST /?payload=late HTTP/1.1\r\nHost: hekad\r\n"))
+
+	// an in-flight request, still sending its body
+	pr, pw := io.Pipe()
+	r, _ := http.NewRequest("POST", "http://"+addr+"/", pr)
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Error(err)
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	pw.Write([]byte("hel"))
+	waitFor(t, "the body", func() bool { return atomic.LoadInt64(&hsi.stats.BytesIn) > 0 })
+
+	hsi.Stop()
+	waitFor(t, "draining", func() bool { return atomic.LoadInt32(&hsi.draining) == 1 })
+	late.Write([]byte("Content-Length: 0\r\n\r\n"))
+	// net/http drops the requests read after Shutdown, the rest get 503
+	if resp, err := http.ReadResponse(bufio.NewReader(late), nil); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != 503 || resp.Header.Get("Retry-After") == "" {
+			t.Errorf("while draining: got %d %v", resp.StatusCode, resp.Header)
+		}
+	}
+	select {
+	case err := <-runErr:
+		t.Fatalf("Run returned before the in-flight request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pw.Write([]byte("lo"))
+	pw.Close()
+	if code := <-codes; code != 201 {
+		t.Errorf("in-flight: got %d, wanted 201", code)
+	}
+	if m := (<-tr.injected).Message; m.GetPayload() != "hello" {
+		t.Errorf("in-flight: got %s", m)
+	}
+	select {
+	case pack := <-tr.injected:
+		t.Errorf("injected while draining: %s", pack.Message)
+	default:
+	}
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run has not returned")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	// no free packs: the handler waits for one, far longer than the drain
+	hsi := &HTTPSimpleInput{drainTimeout: 100 * time.Millisecond, packTimeout: time.Hour}
+	tr := newTestRunner(0)
+	addr, runErr := startRun(t, hsi, tr)
+
+	go func() {
+		if resp, err := http.Post("http://"+addr+"/", "text/plain", strings.NewReader("x")); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	waitFor(t, "the body", func() bool { return atomic.LoadInt64(&hsi.stats.BytesIn) > 0 })
+
+	hsi.Stop()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run has not returned after the drain timeout")
+	}
+	if err := <-tr.errs; !strings.Contains(err.Error(), "not all requests finished") {
+		t.Errorf("got error %v", err)
+	}
+	// the waiting handler gives up when Run returns
+	waitFor(t, "the handler", func() bool { return atomic.LoadInt64(&hsi.stats.Rejected) == 1 })
+	if s := hsi.stats.snapshot(); s.PackTimeouts != 0 {
+		t.Errorf("got %+v, wanted no pack timeouts", s)
+	}
+}
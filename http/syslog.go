@@ -0,0 +1,263 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogMediaTypes are the content types of the syslog bodies,
+// with whether the frames are octet counted
+var syslogMediaTypes = map[string]bool{
+	"application/logplex-1": true,
+	"application/syslog":    false,
+	"text/syslog":           false,
+}
+
+// splitOctetCounted splits the "LEN SP FRAME" frames (RFC 6587, Logplex)
+func splitOctetCounted(body []byte) ([][]byte, error) {
+	var frames [][]byte
+	for {
+		body = bytes.TrimLeft(body, " \r\n")
+		if len(body) == 0 {
+			return frames, nil
+		}
+		i := bytes.IndexByte(body, ' ')
+		if i <= 0 {
+			return frames, errors.New("missing frame length")
+		}
+		n, err := strconv.Atoi(string(body[:i]))
+		if err != nil || n < 0 || n > len(body)-i-1 {
+			return frames, fmt.Errorf("bad frame length %q", body[:i])
+		}
+		frames = append(frames, body[i+1:i+1+n])
+		body = body[i+1+n:]
+	}
+}
+
+// splitSyslogLines splits newline separated syslog lines, or octet counted
+// frames if the body starts with a digit
+func splitSyslogLines(body []byte) ([][]byte, error) {
+	if len(body) > 0 && body[0] >= '0' && body[0] <= '9' {
+		return splitOctetCounted(body)
+	}
+	lines := bytes.Split(body, []byte{'\n'})
+	for i, line := range lines {
+		lines[i] = bytes.TrimSuffix(line, []byte{'\r'})
+	}
+	return lines, nil
+}
+
+// parseSyslog sets the message from an RFC 5424 or RFC 3164 syslog line:
+// severity, timestamp, hostname and pid, the facility, appname, procid,
+// msgid and structured data ("sd.ID.NAME") fields, and the MSG as payload
+func parseSyslog(msg *message.Message, line []byte) error {
+	return parseSyslogLine(msg, line, true)
+}
+
+// parseLogplex is parseSyslog for the Logplex frames, which have no
+// structured data: the MSG follows the "-" of the msgid
+func parseLogplex(msg *message.Message, line []byte) error {
+	return parseSyslogLine(msg, line, false)
+}
+
+func parseSyslogLine(msg *message.Message, line []byte, sd bool) error {
+	s := string(line)
+	if len(s) < 3 || s[0] != '<' {
+		return errors.New("missing syslog priority")
+	}
+	i := strings.IndexByte(s, '>')
+	if i < 2 || i > 4 {
+		return errors.New("bad syslog priority")
+	}
+	pri, err := strconv.Atoi(s[1:i])
+	if err != nil || pri > 191 {
+		return fmt.Errorf("bad syslog priority %q", s[1:i])
+	}
+	msg.SetSeverity(int32(pri & 7))
+	addStringField(msg, "facility", strconv.Itoa(pri>>3))
+	s = s[i+1:]
+	if strings.HasPrefix(s, "1 ") {
+		err = parseRFC5424(msg, s[2:], sd)
+	} else {
+		err = parseRFC3164(msg, s)
+	}
+	if err != nil {
+		return err
+	}
+	if msg.Type == nil {
+		msg.SetType("syslog")
+	}
+	return nil
+}
+
+// parseRFC5424 parses the header after "<PRI>1 ", and the structured data
+// if sd is set. Malformed structured data is kept as part of the MSG.
+func parseRFC5424(msg *message.Message, s string, sd bool) error {
+	parts := strings.SplitN(s, " ", 6)
+	if len(parts) < 6 {
+		return errors.New("short RFC5424 header")
+	}
+	if parts[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, parts[0])
+		if err != nil {
+			return fmt.Errorf("bad timestamp %q: %s", parts[0], err)
+		}
+		msg.SetTimestamp(t.UnixNano())
+	}
+	if parts[1] != "-" {
+		msg.SetHostname(parts[1])
+	}
+	for j, name := range []string{"appname", "procid", "msgid"} {
+		if v := parts[2+j]; v != "-" {
+			addStringField(msg, name, v)
+		}
+	}
+	if pid, err := strconv.ParseInt(parts[3], 10, 32); err == nil {
+		msg.SetPid(int32(pid))
+	}
+	rest := parts[5]
+	if sd {
+		if rest == "-" || strings.HasPrefix(rest, "- ") {
+			rest = rest[1:]
+		} else if params, after, err := parseStructuredData(rest); err == nil {
+			for _, p := range params {
+				addStringField(msg, p[0], p[1])
+			}
+			rest = after
+		}
+		rest = strings.TrimPrefix(rest, " ")
+	}
+	rest = strings.TrimPrefix(rest, "\ufeff")
+	msg.SetPayload(rest)
+	return nil
+}
+
+// parseStructuredData parses the [ID NAME="VALUE" ...] elements into
+// ("sd.ID.NAME", VALUE) pairs, and returns the rest of s
+func parseStructuredData(s string) ([][2]string, string, error) {
+	if !strings.HasPrefix(s, "[") {
+		return nil, s, errors.New("no structured data")
+	}
+	var params [][2]string
+	for strings.HasPrefix(s, "[") {
+		i := strings.IndexAny(s, " ]")
+		if i < 0 {
+			return nil, s, errors.New("unterminated structured data")
+		}
+		id := s[1:i]
+		if !isSDName(id) {
+			return nil, s, fmt.Errorf("bad structured data id %q", id)
+		}
+		s = s[i:]
+		for {
+			s = strings.TrimLeft(s, " ")
+			if strings.HasPrefix(s, "]") {
+				s = s[1:]
+				break
+			}
+			eq := strings.Index(s, `="`)
+			if eq <= 0 || !isSDName(s[:eq]) {
+				return nil, s, fmt.Errorf("bad structured data param in %s", id)
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+			var value []byte
+			for {
+				if s == "" {
+					return nil, s, fmt.Errorf("unterminated param %s in %s", name, id)
+				}
+				c := s[0]
+				s = s[1:]
+				if c == '"' {
+					break
+				}
+				if c == '\\' && s != "" && (s[0] == '"' || s[0] == '\\' || s[0] == ']') {
+					c, s = s[0], s[1:]
+				}
+				value = append(value, c)
+			}
+			params = append(params, [2]string{"sd." + id + "." + name, string(value)})
+		}
+	}
+	if s != "" && s[0] != ' ' {
+		return nil, s, errors.New("no space after structured data")
+	}
+	return params, s, nil
+}
+
+// isSDName reports whether s is a valid SD-ID or PARAM-NAME:
+// 1-32 printable ASCII characters, except '=', ' ', ']' and '"'
+func isSDName(s string) bool {
+	if s == "" || len(s) > 32 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRFC3164 parses the "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG" after the
+// priority; the timestamp is in the local time of the current year
+func parseRFC3164(msg *message.Message, s string) error {
+	if len(s) >= 16 && s[15] == ' ' {
+		if t, err := time.ParseInLocation(time.Stamp, s[:15], time.Local); err == nil {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			msg.SetTimestamp(t.UnixNano())
+			s = s[16:]
+			if i := strings.IndexByte(s, ' '); i > 0 {
+				msg.SetHostname(s[:i])
+				s = s[i+1:]
+			}
+		}
+	}
+	// TAG is alphanumeric, up to 32 characters
+	if i := strings.IndexAny(s, "[: "); i > 0 && i <= 32 {
+		tag, rest := s[:i], s[i:]
+		if rest[0] == '[' {
+			if j := strings.IndexByte(rest, ']'); j > 0 {
+				addStringField(msg, "procid", rest[1:j])
+				if pid, err := strconv.ParseInt(rest[1:j], 10, 32); err == nil {
+					msg.SetPid(int32(pid))
+				}
+				rest = rest[j+1:]
+			}
+		}
+		if strings.HasPrefix(rest, ":") {
+			addStringField(msg, "appname", tag)
+			s = strings.TrimPrefix(rest[1:], " ")
+		}
+	}
+	msg.SetPayload(s)
+	return nil
+}
+
+// addStringField adds a string field, ignoring the (impossible) error
+func addStringField(msg *message.Message, name, value string) {
+	if f, e := message.NewField(name, value, ""); e == nil {
+		msg.AddField(f)
+	}
+}
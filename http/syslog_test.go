@@ -0,0 +1,124 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	for i, tc := range []struct {
+		line     string
+		severity int32
+		host     string
+		payload  string
+		fields   map[string]string
+		logplex  bool
+	}{
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Appl\"ication"] An application event`,
+			5, "mymachine.example.com", "An application event",
+			map[string]string{"facility": "20", "appname": "evntslog", "msgid": "ID47",
+				"sd.exampleSDID@32473.iut": "3", "sd.exampleSDID@32473.eventSource": `Appl"ication`}, false},
+		{`<190>1 2024-01-02T03:04:05+00:00 host app web.1 - - State changed`,
+			6, "host", "State changed",
+			map[string]string{"appname": "app", "procid": "web.1"}, false},
+		{`<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed`,
+			2, "mymachine", "'su root' failed",
+			map[string]string{"facility": "4", "appname": "su", "procid": "123"}, false},
+		{`<190>1 2024-01-02T03:04:05+00:00 host app web.1 - - -v flag`,
+			6, "host", "-v flag", nil, false},
+		{`<190>1 2024-01-02T03:04:05+00:00 host app web.1 - -v flag`,
+			6, "host", "-v flag", nil, false},
+		{`<190>1 2024-01-02T03:04:05+00:00 host app web.1 - [a=b] x`,
+			6, "host", "[a=b] x", nil, false},
+		{`<190>1 2024-01-02T03:04:05+00:00 host app web.1 - [id x="1"]y`,
+			6, "host", `[id x="1"]y`, nil, false},
+		{`<190>1 2024-01-02T03:04:05+00:00 host app web.1 - [INFO] started`,
+			6, "host", "[INFO] started", map[string]string{"procid": "web.1"}, true},
+		{`<190>1 2024-01-02T03:04:05+00:00 host app web.1 - -v flag`,
+			6, "host", "-v flag", nil, true},
+		{`<190>1 2024-01-02T03:04:05+00:00 host app web.1 - - x`,
+			6, "host", "- x", nil, true},
+	} {
+		parse := parseSyslog
+		if tc.logplex {
+			parse = parseLogplex
+		}
+		msg := new(message.Message)
+		if err := parse(msg, []byte(tc.line)); err != nil {
+			t.Errorf("%d. %s", i, err)
+			continue
+		}
+		if msg.GetSeverity() != tc.severity || msg.GetHostname() != tc.host || msg.GetPayload() != tc.payload {
+			t.Errorf("%d. got %s", i, msg)
+		}
+		for k, v := range tc.fields {
+			if f := msg.FindFirstField(k); f == nil || f.GetValueString()[0] != v {
+				t.Errorf("%d. field %s is %v, wanted %q", i, k, f, v)
+			}
+		}
+		for _, f := range msg.Fields {
+			if strings.HasPrefix(f.GetName(), "sd.") && tc.fields[f.GetName()] == "" {
+				t.Errorf("%d. unexpected field %s", i, f.GetName())
+			}
+		}
+	}
+	msg := new(message.Message)
+	parseSyslog(msg, []byte(`<13>1 2003-10-11T22:14:15.003Z h a 42 - -`))
+	if want := time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC).UnixNano(); msg.GetTimestamp() != want || msg.GetPid() != 42 {
+		t.Errorf("got %s", msg)
+	}
+	if err := parseSyslog(new(message.Message), []byte("no priority")); err == nil {
+		t.Error("line without priority should fail")
+	}
+}
+
+func TestLogplex(t *testing.T) {
+	hsi, input := newTestInput(4)
+	frames := []string{
+		"<190>1 2024-01-02T03:04:05+00:00 host app web.1 - State changed from starting to up",
+		"<190>1 2024-01-02T03:04:06+00:00 host app web.1 - Starting process",
+	}
+	var body string
+	for _, f := range frames {
+		body += fmt.Sprintf("%d %s", len(f), f)
+	}
+	body += "99 <190>1 truncated"
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/logplex-1")
+	w := httptest.NewRecorder()
+	hsi.handler(w, r)
+	if w.Code != 201 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	var res batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Accepted != 2 || len(res.Rejected) != 1 {
+		t.Errorf("got %+v", res)
+	}
+	for i := range frames {
+		m := (<-input).Message
+		if m.GetType() != "syslog" || m.GetHostname() != "host" || m.FindFirstField("procid") == nil {
+			t.Errorf("%d. got %s", i, m)
+		}
+	}
+}
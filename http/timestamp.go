@@ -0,0 +1,85 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the accepted textual timestamp formats
+var timeLayouts = []string{time.RFC3339Nano, time.RFC1123Z, time.RFC1123}
+
+// parseTimestamp parses s as an RFC3339 or RFC1123 time, or as a
+// (possibly fractional) epoch in the given unit.
+// If unit is 0, it is guessed from the magnitude: seconds, milli-, micro-
+// or nanoseconds.
+// Returns the time as nanoseconds since the epoch.
+func parseTimestamp(s string, unit time.Duration) (int64, error) {
+	s = strings.TrimSpace(s)
+	if strings.TrimLeft(strings.TrimPrefix(s, "-"), "0123456789.") != "" {
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.UnixNano(), nil
+			}
+		}
+		return 0, fmt.Errorf("error parsing timestamp %q: not RFC3339 or RFC1123", s)
+	}
+	ip, fp := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		ip, fp = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseInt(ip, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing timestamp %q: %s", s, err)
+	}
+	var frac float64
+	if fp != "" {
+		if frac, err = strconv.ParseFloat("0."+fp, 64); err != nil {
+			return 0, fmt.Errorf("error parsing timestamp %q: %s", s, err)
+		}
+	}
+	if unit == 0 {
+		unit = guessUnit(n)
+	}
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, fmt.Errorf("timestamp %q is out of range", s)
+	}
+	ts := n * int64(unit)
+	if n < 0 || strings.HasPrefix(ip, "-") {
+		ts -= int64(frac * float64(unit))
+	} else {
+		ts += int64(frac * float64(unit))
+	}
+	return ts, nil
+}
+
+// guessUnit returns the unit of the epoch n by its magnitude:
+// seconds are below 1e11 (year 5138), milliseconds below 1e14,
+// microseconds below 1e17.
+func guessUnit(n int64) time.Duration {
+	if n < 0 {
+		n = -n
+	}
+	switch {
+	case n < 1e11:
+		return time.Second
+	case n < 1e14:
+		return time.Millisecond
+	case n < 1e17:
+		return time.Microsecond
+	}
+	return time.Nanosecond
+}
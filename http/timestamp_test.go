@@ -0,0 +1,87 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2013, 7, 4, 19, 41, 26, 23588000, time.UTC).UnixNano()
+	for i, tc := range []struct {
+		in   string
+		unit time.Duration
+		want int64
+	}{
+		{"2013-07-04T19:41:26.023588Z", 0, want},
+		{"2013-07-04T21:41:26.023588+02:00", 0, want},
+		{"Thu, 04 Jul 2013 19:41:26 UTC", 0, want - 23588000},
+		{"1372966886.023588", 0, want},
+		{"1372966886", 0, want - 23588000},
+		{"1372966886023", 0, want - 588000},
+		{"1372966886023588", 0, want},
+		{"1372966886023588000", 0, want},
+		{"1372966886023.588", 0, want},
+		{"1372966886", time.Millisecond, 1372966886 * int64(time.Millisecond)},
+		{"86400", 0, 86400 * int64(time.Second)},
+	} {
+		got, err := parseTimestamp(tc.in, tc.unit)
+		if err != nil {
+			t.Errorf("%d. %q: %s", i, tc.in, err)
+			continue
+		}
+		// fractional seconds are parsed as float
+		if d := got - tc.want; d > 1000 || d < -1000 {
+			t.Errorf("%d. %q: got %s, wanted %s", i, tc.in,
+				time.Unix(0, got).UTC(), time.Unix(0, tc.want).UTC())
+		}
+	}
+	for _, in := range []string{"", "abc", "2013-13-45", "1e10"} {
+		if _, err := parseTimestamp(in, 0); err == nil {
+			t.Errorf("%q should fail", in)
+		}
+	}
+}
+
+func TestTimestampSkew(t *testing.T) {
+	hsi, input := newTestInput(4)
+	hsi.maxTimestampSkew = time.Hour
+	post := func(query, ct, body string) int {
+		r, _ := http.NewRequest("POST", "/?"+query, strings.NewReader(body))
+		r.Header.Set("Content-Type", ct)
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w.Code
+	}
+	if code := post("timestamp=2013-07-04T19:41:26Z", "text/plain", "x"); code != 422 {
+		t.Errorf("old timestamp: got %d", code)
+	}
+	now := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	if code := post("timestamp="+now, "text/plain", "x"); code != 201 {
+		t.Errorf("recent timestamp: got %d", code)
+	}
+	<-input
+	// the timestamps of the other formats are checked, too
+	if code := post("", "text/syslog", "<13>1 2003-10-11T22:14:15.003Z h a - - - old"); code != 422 {
+		t.Errorf("old syslog timestamp: got %d", code)
+	}
+	select {
+	case pack := <-input:
+		t.Errorf("injected %s", pack.Message)
+	default:
+	}
+}
@@ -0,0 +1,116 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// TLSConfig holds the TLS settings of the listener
+type TLSConfig struct {
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+	// ClientCAFile is a PEM bundle of CAs - if given, every client must
+	// present a certificate signed by one of them (mutual TLS)
+	ClientCAFile string `toml:"client_cafile"`
+	// MinVersion is the minimal accepted TLS version (1.0, 1.1, 1.2, 1.3)
+	MinVersion string `toml:"min_version"`
+	// Ciphers lists the allowed cipher suites by their Go names
+	// (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+	Ciphers             []string `toml:"ciphers"`
+	PreferServerCiphers bool     `toml:"prefer_server_ciphers"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10, "TLS10": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11, "TLS11": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12, "TLS12": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13, "TLS13": tls.VersionTLS13,
+}
+
+// newTLSConfig returns the *tls.Config described by tc
+func (tc TLSConfig) newTLSConfig() (*tls.Config, error) {
+	if tc.CertFile == "" || tc.KeyFile == "" {
+		return nil, fmt.Errorf("both cert_file and key_file is needed for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading key pair from %s and %s: %s",
+			tc.CertFile, tc.KeyFile, err)
+	}
+	conf := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		PreferServerCipherSuites: tc.PreferServerCiphers,
+		MinVersion:               tls.VersionTLS12,
+	}
+	if tc.MinVersion != "" {
+		v, ok := tlsVersions[strings.ToUpper(tc.MinVersion)]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", tc.MinVersion)
+		}
+		conf.MinVersion = v
+	}
+	if len(tc.Ciphers) > 0 {
+		known := make(map[string]uint16, 32)
+		for _, cs := range tls.CipherSuites() {
+			known[cs.Name] = cs.ID
+		}
+		for _, cs := range tls.InsecureCipherSuites() {
+			known[cs.Name] = cs.ID
+		}
+		conf.CipherSuites = make([]uint16, 0, len(tc.Ciphers))
+		for _, name := range tc.Ciphers {
+			id, ok := known[strings.ToUpper(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite %q", name)
+			}
+			conf.CipherSuites = append(conf.CipherSuites, id)
+		}
+	}
+	if tc.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(tc.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA file %s: %s", tc.ClientCAFile, err)
+		}
+		conf.ClientCAs = x509.NewCertPool()
+		if !conf.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", tc.ClientCAFile)
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// clientSubject returns the subject of the verified client certificate,
+// or the empty string if there is none
+func clientSubject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.String()
+}
+
+// addClientSubject records the subject of the client certificate, if any
+func addClientSubject(msg *message.Message, r *http.Request) {
+	if subj := clientSubject(r); subj != "" {
+		if f, e := message.NewField("ClientSubject", subj, ""); e == nil {
+			msg.AddField(f)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, signed by parent (or self-signed)
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"heka"}},
+		NotBefore:    time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and its key as PEM files
+func (tc testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	kb, err := x509.MarshalECPrivateKey(tc.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (tc testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{tc.der}, PrivateKey: tc.key, Leaf: tc.cert}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hsi-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, "test CA", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "server", &ca).write(t, dir, "server")
+
+	conf, err := TLSConfig{CertFile: certFile, KeyFile: keyFile}.newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MinVersion != tls.VersionTLS12 || conf.ClientAuth != tls.NoClientCert || conf.CipherSuites != nil {
+		t.Errorf("defaults: got min %x, client auth %v, ciphers %v", conf.MinVersion, conf.ClientAuth, conf.CipherSuites)
+	}
+
+	conf, err = TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "tls13",
+		Ciphers:      []string{"tls_ecdhe_ecdsa_with_aes_128_gcm_sha256", "TLS_RSA_WITH_RC4_128_SHA"},
+		ClientCAFile: caFile}.newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MinVersion != tls.VersionTLS13 || conf.ClientAuth != tls.RequireAndVerifyClientCert ||
+		len(conf.CipherSuites) != 2 || conf.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 ||
+		conf.CipherSuites[1] != tls.TLS_RSA_WITH_RC4_128_SHA {
+		t.Errorf("got min %x, client auth %v, ciphers %v", conf.MinVersion, conf.ClientAuth, conf.CipherSuites)
+	}
+
+	for name, tc := range map[string]TLSConfig{
+		"no key":         {CertFile: certFile},
+		"missing file":   {CertFile: certFile, KeyFile: filepath.Join(dir, "nonexistent")},
+		"mismatched key": {CertFile: caFile, KeyFile: keyFile},
+		"bad version":    {CertFile: certFile, KeyFile: keyFile, MinVersion: "1.4"},
+		"bad cipher":     {CertFile: certFile, KeyFile: keyFile, Ciphers: []string{"TLS_NULL"}},
+		"no CA file":     {CertFile: certFile, KeyFile: keyFile, ClientCAFile: filepath.Join(dir, "nonexistent")},
+		"no CA in file":  {CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile},
+	} {
+		if _, err = tc.newTLSConfig(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hsi-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, "test CA", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "server", &ca).write(t, dir, "server")
+	conf, err := TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}.newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subjects := make(chan string, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subjects <- clientSubject(r)
+	}))
+	srv.TLS = conf
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0) // the rejected handshakes
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err = get(newTestCert(t, "client-1", &ca).tlsCert()); err != nil {
+		t.Fatal(err)
+	}
+	if s := <-subjects; s != "CN=client-1,O=heka" {
+		t.Errorf("got subject %q", s)
+	}
+	if err = get(); err == nil {
+		t.Error("no client certificate: accepted")
+	}
+	other := newTestCert(t, "other CA", nil)
+	if err = get(newTestCert(t, "client-2", &other).tlsCert()); err == nil {
+		t.Error("client certificate of an other CA: accepted")
+	}
+
+	if s := clientSubject(&http.Request{}); s != "" {
+		t.Errorf("plain HTTP: got subject %q", s)
+	}
+}
@@ -0,0 +1,264 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"github.com/mozilla-services/heka/message"
+
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// WebhookConfig holds the settings of a webhook endpoint. The webhook's own
+// signature replaces the authentication, the identity is "webhook:name".
+type WebhookConfig struct {
+	// Name defaults to the provider
+	Name string `toml:"name"`
+	Path string `toml:"path"`
+	// Provider is github, gitlab, alertmanager or generic
+	Provider string `toml:"provider"`
+	// Secret is the HMAC key (github, generic with signature_header),
+	// the X-Gitlab-Token (gitlab), or the Bearer token (alertmanager, generic).
+	// Empty means no check.
+	Secret string `toml:"secret"`
+	// SignatureHeader holds the hex HMAC-SHA256 of the body (generic)
+	SignatureHeader string `toml:"signature_header"`
+	// EventHeader holds the event kind (generic)
+	EventHeader string `toml:"event_header"`
+	// Type defaults to provider.event, Logger to the name
+	Type   string `toml:"type"`
+	Logger string `toml:"logger"`
+	// Fields maps field names to dotted paths in the JSON body,
+	// in addition to the provider's well-known fields
+	Fields map[string]string `toml:"fields"`
+}
+
+// webhookFields are the well-known fields of the providers
+var webhookFields = map[string]map[string]string{
+	"github": {
+		"repo":   "repository.full_name",
+		"action": "action",
+		"sender": "sender.login",
+	},
+	"gitlab": {
+		"repo":   "project.path_with_namespace",
+		"action": "object_attributes.action",
+		"user":   "user_username",
+	},
+	"alertmanager": {
+		"status":    "status",
+		"alertname": "groupLabels.alertname",
+		"receiver":  "receiver",
+	},
+	"generic": {},
+}
+
+// webhook is the parsed WebhookConfig
+type webhook struct {
+	WebhookConfig
+	fields map[string]string
+	names  []string
+}
+
+// newWebhooks checks the webhook configs and returns the webhooks
+func newWebhooks(whcs []WebhookConfig) ([]*webhook, error) {
+	whs := make([]*webhook, 0, len(whcs))
+	for _, whc := range whcs {
+		known, ok := webhookFields[whc.Provider]
+		if !ok {
+			return nil, fmt.Errorf("unknown webhook provider %q", whc.Provider)
+		}
+		if whc.Path == "" || whc.Path[0] != '/' {
+			return nil, fmt.Errorf("webhook path %q must start with /", whc.Path)
+		}
+		if whc.Name == "" {
+			whc.Name = whc.Provider
+		}
+		wh := &webhook{WebhookConfig: whc,
+			fields: make(map[string]string, len(known)+len(whc.Fields))}
+		for k, v := range known {
+			wh.fields[k] = v
+		}
+		for k, v := range whc.Fields {
+			wh.fields[k] = v
+		}
+		for k := range wh.fields {
+			wh.names = append(wh.names, k)
+		}
+		sort.Strings(wh.names)
+		whs = append(whs, wh)
+	}
+	return whs, nil
+}
+
+// matchWebhook returns the webhook of the path, or nil
+func (hsi *HTTPSimpleInput) matchWebhook(path string) *webhook {
+	for _, wh := range hsi.webhooks {
+		if wh.Path == path {
+			return wh
+		}
+	}
+	return nil
+}
+
+// authenticate checks the provider's signature or token
+func (wh *webhook) authenticate(r *http.Request) (*identity, error) {
+	id := &identity{Name: "webhook:" + wh.Name}
+	if wh.Secret == "" {
+		return id, nil
+	}
+	switch {
+	case wh.Provider == "github":
+		return id, checkBodySignature(r, "X-Hub-Signature-256", wh.Secret)
+	case wh.Provider == "generic" && wh.SignatureHeader != "":
+		return id, checkBodySignature(r, wh.SignatureHeader, wh.Secret)
+	case wh.Provider == "gitlab":
+		return id, checkToken(r.Header.Get("X-Gitlab-Token"), "X-Gitlab-Token", wh.Secret)
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, withChallenge(unauthorized("Bearer token needed"), `Bearer realm="hekad"`)
+	}
+	return id, withChallenge(checkToken(auth[7:], "Bearer token", wh.Secret), `Bearer realm="hekad"`)
+}
+
+// checkToken compares the token to the secret in constant time
+func checkToken(token, what, secret string) error {
+	if token == "" {
+		return unauthorized("%s needed", what)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return forbidden("bad %s", what)
+	}
+	return nil
+}
+
+// checkBodySignature checks the hex HMAC-SHA256 of the body
+// (with an optional "sha256=" prefix) in the header
+func checkBodySignature(r *http.Request, header, secret string) error {
+	sigHex := r.Header.Get(header)
+	if sigHex == "" {
+		return unauthorized("%s needed", header)
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(sigHex, "sha256="))
+	if err != nil || len(sig) == 0 {
+		return forbidden("bad %s", header)
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return forbidden("bad signature")
+	}
+	return nil
+}
+
+// parse sets the message from the webhook's JSON body: the type from the
+// event kind, the well-known fields, and the raw body as payload
+func (wh *webhook) parse(msg *message.Message, r *http.Request, body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return fmt.Errorf("error parsing %s webhook: %s", wh.Provider, err)
+	}
+	var kind string
+	switch wh.Provider {
+	case "github":
+		kind = r.Header.Get("X-GitHub-Event")
+	case "gitlab":
+		if kind, _ = jsonPath(obj, "object_kind").(string); kind == "" {
+			kind = r.Header.Get("X-Gitlab-Event")
+		}
+	case "alertmanager":
+		kind = "alert"
+	default:
+		if wh.EventHeader != "" {
+			kind = r.Header.Get(wh.EventHeader)
+		}
+	}
+	if msg.Type == nil {
+		switch {
+		case wh.Type != "":
+			msg.SetType(wh.Type)
+		case kind != "":
+			msg.SetType(wh.Provider + "." + strings.Replace(strings.ToLower(kind), " ", "_", -1))
+		default:
+			msg.SetType(wh.Provider)
+		}
+	}
+	if msg.Logger == nil {
+		if wh.Logger != "" {
+			msg.SetLogger(wh.Logger)
+		} else {
+			msg.SetLogger(wh.Name)
+		}
+	}
+	for _, name := range wh.names {
+		v := jsonPath(obj, wh.fields[name])
+		if v == nil && wh.Provider == "alertmanager" && name == "alertname" {
+			v = jsonPath(obj, "commonLabels.alertname")
+		}
+		switch v.(type) {
+		case nil, map[string]interface{}, []interface{}:
+			continue
+		}
+		f, err := jsonField(name, []interface{}{v})
+		if err != nil {
+			return err
+		}
+		msg.AddField(f)
+	}
+	if kind != "" {
+		if f, e := message.NewField("event", kind, ""); e == nil {
+			msg.AddField(f)
+		}
+	}
+	if delivery := r.Header.Get("X-GitHub-Delivery"); delivery != "" && wh.Provider == "github" {
+		if f, e := message.NewField("delivery", delivery, ""); e == nil {
+			msg.AddField(f)
+		}
+	}
+	msg.SetPayload(string(body))
+	return nil
+}
+
+// jsonPath returns the value at the dotted path in obj, or nil
+func jsonPath(obj map[string]interface{}, path string) interface{} {
+	var v interface{} = obj
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if v, ok = m[k]; !ok {
+			return nil
+		}
+	}
+	return v
+}
@@ -0,0 +1,94 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is Tamás Gulácsi.
+# Portions created by the Initial Developer are Copyright (C) 2013
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhooks(t *testing.T) {
+	hsi, input := newTestInput(4)
+	var err error
+	if hsi.webhooks, err = newWebhooks([]WebhookConfig{
+		{Path: "/gh", Provider: "github", Secret: "s3cr3t"},
+		{Path: "/gl", Provider: "gitlab", Secret: "tok"},
+		{Path: "/am", Provider: "alertmanager", Name: "prom"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// other paths need authentication
+	hsi.auths = []authenticator{tokenAuth{{Token: "x", Name: "x"}}}
+
+	post := func(path, body string, hdr map[string]string) int {
+		r, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for k, v := range hdr {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		hsi.handler(w, r)
+		return w.Code
+	}
+
+	ghBody := `{"action": "opened", "repository": {"full_name": "a/b"}, "sender": {"login": "joe"}}`
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte(ghBody))
+	sig := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if code := post("/gh", ghBody, map[string]string{"X-GitHub-Event": "pull_request"}); code != 401 {
+		t.Errorf("github without signature: got %d", code)
+	}
+	if code := post("/gh", ghBody+" ", map[string]string{"X-Hub-Signature-256": sig}); code != 403 {
+		t.Errorf("github with bad signature: got %d", code)
+	}
+	if code := post("/gh", ghBody, map[string]string{
+		"X-Hub-Signature-256": sig, "X-GitHub-Event": "pull_request"}); code != 201 {
+		t.Fatalf("github: got %d", code)
+	}
+	m := (<-input).Message
+	if m.GetType() != "github.pull_request" || m.GetLogger() != "github" || m.GetPayload() != ghBody {
+		t.Errorf("github: got %s", m)
+	}
+	for k, v := range map[string]string{"repo": "a/b", "action": "opened", "sender": "joe"} {
+		if f := m.FindFirstField(k); f == nil || f.GetValueString()[0] != v {
+			t.Errorf("github: field %s is %v, wanted %q", k, f, v)
+		}
+	}
+
+	if code := post("/gl", `{"object_kind": "push"}`, map[string]string{"X-Gitlab-Token": "bad"}); code != 403 {
+		t.Errorf("gitlab with bad token: got %d", code)
+	}
+	if code := post("/gl", `{"object_kind": "push", "project": {"path_with_namespace": "g/p"}}`,
+		map[string]string{"X-Gitlab-Token": "tok"}); code != 201 {
+		t.Fatalf("gitlab: got %d", code)
+	}
+	if m = (<-input).Message; m.GetType() != "gitlab.push" {
+		t.Errorf("gitlab: got %s", m)
+	}
+
+	if code := post("/am", `{"status": "firing", "receiver": "heka", "commonLabels": {"alertname": "DiskFull"}}`, nil); code != 201 {
+		t.Fatalf("alertmanager: got %d", code)
+	}
+	m = (<-input).Message
+	if f := m.FindFirstField("alertname"); m.GetLogger() != "prom" || f == nil || f.GetValueString()[0] != "DiskFull" {
+		t.Errorf("alertmanager: got %s", m)
+	}
+
+	if code := post("/other", `{}`, nil); code != 401 {
+		t.Errorf("other path without auth: got %d", code)
+	}
+}